@@ -0,0 +1,111 @@
+package client
+
+import (
+	"bytes"
+	"errors"
+)
+
+// ErrBadChecksum is returned when an invoice's bech32 checksum does not match.
+var ErrBadChecksum = errors.New("invalid bech32 checksum")
+
+var generator = [5]uint32{0x3b6a57b2, 0x26508e6d, 0x1ea119fa, 0x3d4233dd, 0x2a1462b3}
+
+// polymod computes the bech32 checksum polynomial over 5-bit values.
+func polymod(values []byte) uint32 {
+	chk := uint32(1)
+	for _, v := range values {
+		top := chk >> 25
+		chk = (chk&0x1ffffff)<<5 ^ uint32(v)
+		for i := 0; i < 5; i++ {
+			if (top>>i)&1 == 1 {
+				chk ^= generator[i]
+			}
+		}
+	}
+	return chk
+}
+
+// hrpExpand prepares the human-readable part for checksum computation.
+func hrpExpand(hrp []byte) []byte {
+	out := make([]byte, 0, len(hrp)*2+1)
+	for _, c := range hrp {
+		out = append(out, c>>5)
+	}
+	out = append(out, 0)
+	for _, c := range hrp {
+		out = append(out, c&31)
+	}
+	return out
+}
+
+// verifyChecksum reports whether data, including its trailing six checksum
+// words, carries a valid bech32 checksum for hrp. Unlike BIP-173 there is
+// no limit on the overall length, as BOLT11 requires.
+func verifyChecksum(hrp, data []byte) bool {
+	return polymod(append(hrpExpand(hrp), data...)) == 1
+}
+
+// createChecksum returns the six checksum words for hrp and data.
+func createChecksum(hrp, data []byte) []byte {
+	values := append(hrpExpand(hrp), data...)
+	values = append(values, 0, 0, 0, 0, 0, 0)
+	mod := polymod(values) ^ 1
+	checksum := make([]byte, 6)
+	for i := range checksum {
+		checksum[i] = byte(mod >> (5 * (5 - i)) & 31)
+	}
+	return checksum
+}
+
+// fromChars maps bech32 characters to their 5-bit values.
+func fromChars(chars []byte) ([]byte, error) {
+	words := make([]byte, len(chars))
+	for i, c := range chars {
+		v := bytes.IndexByte(charSet, c)
+		if v == -1 {
+			return nil, errors.New("invalid bech32 character")
+		}
+		words[i] = byte(v)
+	}
+	return words, nil
+}
+
+// toChars maps 5-bit values to their bech32 characters.
+func toChars(words []byte) []byte {
+	chars := make([]byte, len(words))
+	for i, w := range words {
+		chars[i] = charSet[w]
+	}
+	return chars
+}
+
+// convertBits regroups a slice of fromBits-wide values into toBits-wide
+// values. With pad set, a trailing incomplete group is zero-padded;
+// otherwise leftover bits are dropped.
+func convertBits(data []byte, fromBits, toBits uint, pad bool) []byte {
+	var acc uint
+	var bits uint
+	maxv := uint(1)<<toBits - 1
+	out := make([]byte, 0, len(data)*int(fromBits)/int(toBits)+1)
+	for _, v := range data {
+		acc = acc<<fromBits | uint(v)
+		bits += fromBits
+		for bits >= toBits {
+			bits -= toBits
+			out = append(out, byte(acc>>bits&maxv))
+		}
+	}
+	if pad && bits > 0 {
+		out = append(out, byte(acc<<(toBits-bits)&maxv))
+	}
+	return out
+}
+
+// wordsToUint interprets 5-bit words as a big-endian unsigned integer.
+func wordsToUint(words []byte) uint64 {
+	var n uint64
+	for _, w := range words {
+		n = n<<5 | uint64(w)
+	}
+	return n
+}
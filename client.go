@@ -2,12 +2,15 @@ package client
 
 import (
 	"bytes"
+	"context"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"net/url"
+	"time"
 )
 
 var (
@@ -17,24 +20,65 @@ var (
 	CustomRoutingBudgetMismatch = errors.New("routing budget not respected")
 	DestinationNotProxied       = errors.New("destination is not obscured")
 	InvalidProxyInvoice         = errors.New("invalid proxy invoice")
+	ExpiryExceedsOriginal       = errors.New("proxy invoice expires after original")
+	FinalCltvTooLow             = errors.New("proxy min_final_cltv_expiry below original")
+	FeaturesMismatch            = errors.New("proxy invoice requires features the original does not")
+	InvalidPaymentSecret        = errors.New("proxy payment secret missing or reused")
+	FeePolicyExceeded           = errors.New("relay fee exceeds fee policy")
 )
 
+// maxResponseBytes bounds how much of a relay response the client reads
+const maxResponseBytes = 1 << 20
+
 type LNProxy struct {
 	url.URL
 	http.Client
 	BaseMsat uint64
 	Ppm      uint64
-	logger   *Logger
+	// Networks lists the networks the client accepts invoices for.
+	// When empty only Mainnet is accepted.
+	Networks []Network
+	// RelayChosenBudget lets the relay pick the routing budget whenever the
+	// caller does not give one. The budget it picks must then stay within
+	// MaxBudgetMsat and MaxBudgetPpm, see BudgetCeiling.
+	RelayChosenBudget bool
+	MaxBudgetMsat     uint64
+	MaxBudgetPpm      uint64
+	// RequestTimeout limits each request to the relay, in addition to any
+	// deadline on the context and the embedded Client's Timeout. Zero
+	// means no limit. Each retry gets its own RequestTimeout.
+	RequestTimeout time.Duration
+	Retry          RetryPolicy
+	// Style is how requests are sent to the relay. With StyleAuto the
+	// relay's spec document is fetched and cached for SpecTTL.
+	Style   RequestStyle
+	SpecTTL time.Duration
+	// ZeroAmount decides whether amountless invoices are wrapped, see
+	// WithZeroAmount. ZeroAmountMsat is the amount they are expected to be
+	// paid.
+	ZeroAmount     ZeroAmountPolicy
+	ZeroAmountMsat uint64
+	// MinRemaining is the lifetime an invoice must have left to be
+	// wrapped; expired invoices are always refused. Now is the clock this
+	// is judged by, time.Now when nil.
+	MinRemaining time.Duration
+	Now          func() time.Time
+	logger       *Logger
+	health       *relayHealth
+	spec         *specCache
 }
 
 // NewLNProxy creates a new LNProxy client with the default logger
 func NewLNProxy(baseURL url.URL, baseMsat, ppm uint64) *LNProxy {
 	return &LNProxy{
-		URL:      baseURL,
-		Client:   http.Client{},
-		BaseMsat: baseMsat,
-		Ppm:      ppm,
-		logger:   DefaultLogger().WithComponent("LNProxy"),
+		URL:          baseURL,
+		Client:       http.Client{},
+		BaseMsat:     baseMsat,
+		Ppm:          ppm,
+		logger:       DefaultLogger().WithComponent("LNProxy"),
+		MinRemaining: DefaultMinRemaining,
+		health:       newRelayHealth(DefaultHealthPolicy),
+		spec:         &specCache{},
 	}
 }
 
@@ -44,111 +88,285 @@ func (x *LNProxy) WithLogger(logger *Logger) *LNProxy {
 	return x
 }
 
+// WithNetworks sets the networks the LNProxy client accepts invoices for
+func (x *LNProxy) WithNetworks(networks ...Network) *LNProxy {
+	x.Networks = networks
+	return x
+}
+
+// allowsNetwork reports whether invoices for network are accepted
+func (x *LNProxy) allowsNetwork(network Network) bool {
+	if len(x.Networks) == 0 {
+		return network == Mainnet
+	}
+	for _, n := range x.Networks {
+		if n == network {
+			return true
+		}
+	}
+	return false
+}
+
+// checkNetwork rejects invoices whose prefix names a network the client does
+// not accept. Invoices without a recognisable prefix are left to the relay
+// or the validator to reject.
+func (x *LNProxy) checkNetwork(invoice string) error {
+	network, err := DetectNetwork(invoice)
+	if err != nil {
+		return nil
+	}
+	if !x.allowsNetwork(network) {
+		x.logger.Error("Invoice is for %s, which this client does not accept", network)
+		return fmt.Errorf("%w: %s", NetworkNotAllowed, network)
+	}
+	return nil
+}
+
+// RequestProxy is RequestProxyContext with a background context
 func (x *LNProxy) RequestProxy(invoice string, routing_msat uint64) (proxy_invoice string, err error) {
-	x.logger.Debug("Requesting proxy invoice for %s with routing budget %d msat", invoice, routing_msat)
+	return x.RequestProxyContext(context.Background(), invoice, routing_msat)
+}
+
+// RequestProxyContext is RequestProxyWithOptions with default options
+func (x *LNProxy) RequestProxyContext(ctx context.Context, invoice string, routing_msat uint64) (proxy_invoice string, err error) {
+	return x.RequestProxyWithOptions(ctx, invoice, routing_msat, RequestOptions{})
+}
+
+// RequestProxyWithOptions asks the relay to wrap invoice with a routing budget
+// of routing_msat, with the description opts asks for. A routing_msat of zero
+// requests the budget given by the client's fee policy, see RoutingBudget, or
+// leaves the choice to the relay when RelayChosenBudget is set. A relay may
+// only choose when BudgetCeiling limits its choice; otherwise the request
+// fails with UnboundedRelayBudget.
+//
+// The request is sent in the client's Style and abandoned when ctx is done or
+// after the client's Timeout, whichever comes first; running out of time
+// yields a *TimeoutError, and a relay answering with an error a *RelayError.
+// Transient failures are retried as set out by the client's Retry policy.
+// Amountless invoices are refused unless the client's ZeroAmount policy
+// allows them, and invoices with less than MinRemaining left fail with
+// InvoiceExpired. Onion relays are only contacted through a proxy, see
+// WithSOCKS5. While the relay's circuit is open the request fails with
+// RelayCircuitOpen without reaching the relay, see HealthPolicy.
+func (x *LNProxy) RequestProxyWithOptions(ctx context.Context, invoice string, routing_msat uint64, opts RequestOptions) (proxy_invoice string, err error) {
+	proxy_invoice, _, err = x.requestProxy(ctx, invoice, routing_msat, opts)
+	return proxy_invoice, err
+}
+
+// requestProxy implements RequestProxyWithOptions, also returning the health
+// sample of the request that produced the proxy invoice
+func (x *LNProxy) requestProxy(ctx context.Context, invoice string, routing_msat uint64, opts RequestOptions) (proxy_invoice string, sample sampleHandle, err error) {
+	if err := x.checkTransport(); err != nil {
+		return "", sampleHandle{}, err
+	}
+	if err := opts.check(); err != nil {
+		x.logger.Error("Refusing request: %v", err)
+		return "", sampleHandle{}, err
+	}
+	if err := x.checkNetwork(invoice); err != nil {
+		return "", sampleHandle{}, err
+	}
+	// Invoices that cannot be decoded are left to the relay to reject
+	original, decodeErr := DecodeInvoice([]byte(invoice))
+	if err := x.checkAmount(original); err != nil {
+		return "", sampleHandle{}, err
+	}
+	if err := x.checkExpiry(original); err != nil {
+		return "", sampleHandle{}, err
+	}
+	
+	relayChosen := routing_msat == 0 && x.RelayChosenBudget
+	if _, limited := x.BudgetCeiling(0); relayChosen && !limited {
+		x.logger.Error("Refusing relay-chosen budget without MaxBudgetMsat, MaxBudgetPpm or a fee policy")
+		return "", sampleHandle{}, UnboundedRelayBudget
+	}
+	if routing_msat == 0 && !relayChosen {
+		if decodeErr != nil {
+			x.logger.Error("Cannot compute routing budget: %v", decodeErr)
+			return "", sampleHandle{}, fmt.Errorf("invalid original invoice: %w", decodeErr)
+		}
+		routing_msat = x.RoutingBudget(x.budgetAmount(original))
+	}
+	
+	var routingParam string
+	if relayChosen {
+		x.logger.Debug("Requesting proxy invoice for %s with relay-chosen routing budget", invoice)
+	} else {
+		x.logger.Debug("Requesting proxy invoice for %s with routing budget %d msat", invoice, routing_msat)
+		routingParam = fmt.Sprintf("%d", routing_msat)
+	}
+	
+	style := x.Style
+	if style == StyleAuto {
+		style, err = x.autoStyle(ctx, original, routing_msat, relayChosen, opts)
+		if err != nil {
+			return "", sampleHandle{}, err
+		}
+	}
+	
+	attempts := x.Retry.attempts()
+	for attempt := 1; ; attempt++ {
+		x.logger.Debug("Sending %s request to %s (attempt %d/%d)", style, x.URL.String(), attempt, attempts)
+		proxy_invoice, sample, err = x.send(ctx, style, invoice, routingParam, opts)
+		if err == nil {
+			break
+		}
+		if attempt >= attempts || !x.Retry.retryable(err) {
+			if attempts > 1 {
+				x.logger.Warn("Attempt %d/%d to %s failed, giving up: %v", attempt, attempts, x.URL.String(), err)
+			}
+			return "", sampleHandle{}, err
+		}
+		
+		wait := x.Retry.backoff(attempt)
+		x.logger.Warn("Attempt %d/%d to %s failed, retrying in %s: %v", attempt, attempts, x.URL.String(), wait, err)
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			x.logger.Warn("Giving up on %s during backoff: %v", x.URL.String(), ctx.Err())
+			return "", sampleHandle{}, x.timeoutError(ctx, ctx.Err())
+		case <-timer.C:
+		}
+	}
+	
+	if relayChosen && original != nil {
+		if proxy, err := DecodeInvoice([]byte(proxy_invoice)); err == nil && proxy.AmountMsat >= original.AmountMsat {
+			x.logger.Info("Relay chose a routing budget of %d msat", proxy.AmountMsat-original.AmountMsat)
+		}
+	}
+	
+	x.logger.Debug("Successfully received proxy invoice: %s", proxy_invoice)
+	return proxy_invoice, sample, nil
+}
+
+// newRequest builds the HTTP request for invoice in the given style
+func (x *LNProxy) newRequest(ctx context.Context, style RequestStyle, invoice, routingParam string, opts RequestOptions) (*http.Request, error) {
+	var descriptionHash string
+	if opts.DescriptionHash != nil {
+		descriptionHash = hex.EncodeToString(opts.DescriptionHash)
+	}
+	
+	if style == StylePath {
+		u := x.URL.JoinPath(invoice)
+		query := url.Values{}
+		if routingParam != "" {
+			query.Set("routing_msat", routingParam)
+		}
+		if opts.Description != "" {
+			query.Set("description", opts.Description)
+		}
+		if descriptionHash != "" {
+			query.Set("description_hash", descriptionHash)
+		}
+		u.RawQuery = query.Encode()
+		return http.NewRequestWithContext(ctx, "GET", u.String(), nil)
+	}
 	
 	params, _ := json.Marshal(struct {
-		Invoice     string `json:"invoice"`
-		RoutingMsat string `json:"routing_msat"`
+		Invoice         string `json:"invoice"`
+		RoutingMsat     string `json:"routing_msat,omitempty"`
+		Description     string `json:"description,omitempty"`
+		DescriptionHash string `json:"description_hash,omitempty"`
 	}{
-		Invoice:     invoice,
-		RoutingMsat: fmt.Sprintf("%d", routing_msat),
+		Invoice:         invoice,
+		RoutingMsat:     routingParam,
+		Description:     opts.Description,
+		DescriptionHash: descriptionHash,
 	})
+	req, err := http.NewRequestWithContext(ctx, "POST", x.URL.String(), bytes.NewBuffer(params))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return req, nil
+}
+
+// send makes a single request to the relay and returns the health sample
+// recording it. A relay answering with an error yields a *RelayError.
+func (x *LNProxy) send(ctx context.Context, style RequestStyle, invoice, routingParam string, opts RequestOptions) (proxy_invoice string, sample sampleHandle, err error) {
+	parent := ctx
+	if x.RequestTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, x.RequestTimeout)
+		defer cancel()
+	}
 	
-	buf := bytes.NewBuffer(params)
-	req, err := http.NewRequest("POST", x.URL.String(), buf)
+	req, err := x.newRequest(ctx, style, invoice, routingParam, opts)
 	if err != nil {
 		x.logger.Error("Failed to create HTTP request: %v", err)
-		return "", err
+		return "", sampleHandle{}, err
 	}
 	
-	req.Header.Set("Content-Type", "application/json")
-	x.logger.Debug("Sending request to %s", x.URL.String())
+	if err := x.health.allow(); err != nil {
+		x.logger.Warn("Relay %s is out of rotation: %v", x.URL.String(), err)
+		return "", sampleHandle{}, err
+	}
+	start := time.Now()
+	responsive := false
+	defer func() {
+		if parent.Err() != nil {
+			x.health.abandon()
+			return
+		}
+		sample = x.health.observe(time.Since(start), !responsive, err)
+	}()
+	
 	resp, err := x.Client.Do(req)
 	if err != nil {
 		x.logger.Error("HTTP request failed: %v", err)
-		return "", err
+		return "", sampleHandle{}, x.timeoutError(parent, err)
 	}
 	defer resp.Body.Close()
 	
-	dec := json.NewDecoder(resp.Body)
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseBytes+1))
+	if err != nil {
+		x.logger.Error("Failed to read response body: %v", err)
+		return "", sampleHandle{}, x.timeoutError(parent, err)
+	}
+	if len(body) > maxResponseBytes {
+		x.logger.Error("Response from %s exceeds %d bytes", x.URL.String(), maxResponseBytes)
+		return "", sampleHandle{}, fmt.Errorf("relay response larger than %d bytes", maxResponseBytes)
+	}
+	// Legacy path-style relays answer in plain text
+	text := bytes.TrimSpace(body)
+	plain := style == StylePath && !bytes.HasPrefix(text, []byte("{"))
+	
 	if resp.StatusCode != http.StatusOK {
 		x.logger.Warn("Received non-OK status code: %d", resp.StatusCode)
+		// A relay rejecting the request is healthy unless it is failing
+		// or overloaded
+		responsive = resp.StatusCode < 500 && resp.StatusCode != http.StatusTooManyRequests
 		r := struct {
 			Reason string `json:"reason"`
 			Status string `json:"status,omitempty"`
 		}{}
-		err = dec.Decode(&r)
-		if err != nil && err != io.EOF {
-			body, err := io.ReadAll(resp.Body)
-			if err != nil {
-				x.logger.Error("Failed to read response body: %v", err)
-				return "", err
-			}
+		relayErr := &RelayError{URL: x.URL.String(), HTTPStatus: resp.StatusCode}
+		if plain {
+			r.Reason = string(text)
+		} else if err := json.Unmarshal(body, &r); err != nil && len(text) > 0 {
 			x.logger.Error("Malformed lnproxy response: %s", string(body))
-			return "", fmt.Errorf("malformed lnproxy response: %s", string(body))
+			relayErr.Reason = "malformed lnproxy response: " + string(body)
+			return "", sampleHandle{}, relayErr
 		}
+		relayErr.Status, relayErr.Reason = r.Status, r.Reason
 		x.logger.Error("LNProxy error: %s", r.Reason)
-		return "", errors.Join(LNProxyError, errors.New(r.Reason))
+		return "", sampleHandle{}, relayErr
 	}
 	
 	r := struct {
 		ProxyInvoice string `json:"proxy_invoice"`
 	}{}
-	err = dec.Decode(&r)
-	if err != nil && err != io.EOF {
+	if plain {
+		r.ProxyInvoice = string(text)
+	} else if err := json.Unmarshal(body, &r); err != nil && len(text) > 0 {
 		x.logger.Error("Failed to decode successful response: %v", err)
-		return "", err
+		return "", sampleHandle{}, err
 	}
 	
-	x.logger.Debug("Successfully received proxy invoice: %s", r.ProxyInvoice)
-	return r.ProxyInvoice, nil
-}
-
-func ValidateProxyInvoice(invoice, proxy_invoice string, routing_msat uint64) (bool, error) {
-	logger := DefaultLogger().WithComponent("Validator")
-	logger.Debug("Validating proxy invoice against original invoice")
-	
-	original, err := ParseInvoice([]byte(invoice))
-	if err != nil {
-		logger.Error("Failed to parse original invoice: %v", err)
-		return false, errors.New("invalid original invoice")
+	if err := x.checkNetwork(r.ProxyInvoice); err != nil {
+		return "", sampleHandle{}, fmt.Errorf("%w: %w", InvalidProxyInvoice, err)
 	}
-	
-	proxy, err := ParseInvoice([]byte(proxy_invoice))
-	if err != nil {
-		logger.Error("Failed to parse proxy invoice: %v", err)
-		return false, InvalidProxyInvoice
-	}
-	
-	logger.Debug("Original amount: %d msat, Proxy amount: %d msat", original.AmountMsat, proxy.AmountMsat)
-	
-	if bytes.Compare(original.PaymentHash, proxy.PaymentHash) != 0 {
-		logger.Error("Payment hash mismatch")
-		return false, PaymentHashMismatch
-	}
-	
-	if original.DescriptionHash != proxy.DescriptionHash {
-		logger.Error("Description hash mismatch")
-		return false, DescriptionMismatch
-	}
-	
-	if bytes.Compare(original.Description, proxy.Description) != 0 {
-		logger.Error("Description mismatch")
-		return false, DescriptionMismatch
-	}
-	
-	if (original.AmountMsat + routing_msat) != proxy.AmountMsat {
-		logger.Error("Routing budget mismatch: expected %d, got %d", 
-			original.AmountMsat+routing_msat, proxy.AmountMsat)
-		return false, CustomRoutingBudgetMismatch
-	}
-	
-	if bytes.Compare(original.Signature, proxy.Signature) == 0 {
-		logger.Error("Destination not proxied (signatures match)")
-		return false, DestinationNotProxied
-	}
-	
-	logger.Debug("Proxy invoice validation successful")
-	return true, nil
+	responsive = true
+	return r.ProxyInvoice, sampleHandle{}, nil
 }
@@ -2,13 +2,18 @@ package client
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"io"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
 	"strings"
 	"testing"
+	"time"
+
+	"github.com/decred/dcrd/dcrec/secp256k1/v4"
 )
 
 func TestRequestProxy(t *testing.T) {
@@ -125,3 +130,167 @@ func TestRequestProxyError(t *testing.T) {
 		t.Error("Expected log message 'LNProxy error: Invalid invoice' not found")
 	}
 }
+
+// relayKey is the node key of the relays in these tests.
+var relayKey = secp256k1.PrivKeyFromBytes(bytes.Repeat([]byte{0x42}, 32))
+
+// testInvoice returns an invoice with the fields of a typical wallet
+// invoice; tests adjust it before encoding.
+func testInvoice() *Invoice {
+	return &Invoice{
+		AmountMsat:    250_000_000,
+		Timestamp:     time.Unix(1496314658, 0),
+		PaymentHash:   mustHex("0001020304050607080900010203040506070809000102030405060708090102"),
+		PaymentSecret: bytes.Repeat([]byte{0x11}, 32),
+		Description:   "1 cup coffee",
+		Expiry:        time.Minute,
+		Features:      FeatureVector{8, 14},
+	}
+}
+
+// testNow is the clock of the clients in these tests, stopped when
+// testInvoice is issued.
+func testNow() time.Time {
+	return testInvoice().Timestamp
+}
+
+// testProxyInvoice returns the invoice an honest relay would issue for
+// original with the given routing budget.
+func testProxyInvoice(original *Invoice, routingMsat uint64) *Invoice {
+	proxy := *original
+	proxy.AmountMsat += routingMsat
+	proxy.Timestamp = original.Timestamp.Add(42 * time.Second)
+	proxy.Expiry = original.Expiry - 42*time.Second
+	proxy.PaymentSecret = bytes.Repeat([]byte{0x22}, 32)
+	return &proxy
+}
+
+func TestRequestProxyNetworks(t *testing.T) {
+	regtestOriginal := testInvoice()
+	regtestOriginal.Network = Regtest
+	regtestInvoice := mustEncode(t, regtestOriginal, testKey)
+	regtestProxy := mustEncode(t, testProxyInvoice(regtestOriginal, 100_000), relayKey)
+	testnetOriginal := testInvoice()
+	testnetOriginal.Network = Testnet
+	testnetProxy := mustEncode(t, testProxyInvoice(testnetOriginal, 100_000), relayKey)
+
+	proxyInvoice := regtestProxy
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		json.NewEncoder(w).Encode(struct {
+			ProxyInvoice string `json:"proxy_invoice"`
+		}{proxyInvoice})
+	}))
+	defer server.Close()
+	serverURL, _ := url.Parse(server.URL)
+	logger := NewLogger(LevelError, io.Discard)
+
+	// A mainnet client must not send a regtest invoice to the relay
+	mainnet := NewLNProxy(*serverURL, 1000, 500).WithLogger(logger).WithClock(testNow)
+	if _, err := mainnet.RequestProxy(regtestInvoice, 100_000); !errors.Is(err, NetworkNotAllowed) {
+		t.Errorf("Expected NetworkNotAllowed, got %v", err)
+	}
+	if requests != 0 {
+		t.Errorf("Expected no request to reach the relay, got %d", requests)
+	}
+
+	regtest := NewLNProxy(*serverURL, 1000, 500).WithLogger(logger).WithClock(testNow).WithNetworks(Regtest)
+	got, err := regtest.RequestProxy(regtestInvoice, 100_000)
+	if err != nil || got != regtestProxy {
+		t.Fatalf("Expected regtest proxy invoice, got %q, %v", got, err)
+	}
+	if ok, err := regtest.ValidateProxyInvoice(regtestInvoice, got, 100_000); !ok || err != nil {
+		t.Errorf("Expected regtest proxy invoice to validate, got %v", err)
+	}
+	if _, err := mainnet.ValidateProxyInvoice(regtestInvoice, got, 100_000); !errors.Is(err, NetworkNotAllowed) {
+		t.Errorf("Expected NetworkNotAllowed from mainnet validator, got %v", err)
+	}
+
+	// The relay answers with an invoice for a network the client refuses
+	proxyInvoice = testnetProxy
+	if _, err := regtest.RequestProxy(regtestInvoice, 100_000); !errors.Is(err, NetworkNotAllowed) || !errors.Is(err, InvalidProxyInvoice) {
+		t.Errorf("Expected NetworkNotAllowed proxy invoice error, got %v", err)
+	}
+
+	both := NewLNProxy(*serverURL, 1000, 500).WithLogger(logger).WithClock(testNow).WithNetworks(Regtest, Testnet)
+	if _, err := both.ValidateProxyInvoice(regtestInvoice, testnetProxy, 100_000); !errors.Is(err, NetworkMismatch) {
+		t.Errorf("Expected NetworkMismatch, got %v", err)
+	}
+}
+
+func TestRequestProxyContext(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// A stuck relay that never answers
+		<-release
+	}))
+	defer server.Close()
+	defer close(release)
+	serverURL, _ := url.Parse(server.URL)
+	invoice := mustEncode(t, testInvoice(), testKey)
+	logger := NewLogger(LevelError, io.Discard)
+
+	// Client-wide timeout
+	x := NewLNProxy(*serverURL, 1000, 500).WithLogger(logger).WithClock(testNow)
+	x.RequestTimeout = 50 * time.Millisecond
+	start := time.Now()
+	_, err := x.RequestProxy(invoice, 1500)
+	var timeoutErr *TimeoutError
+	if !errors.As(err, &timeoutErr) || timeoutErr.Limit != x.RequestTimeout {
+		t.Fatalf("Expected TimeoutError after %s, got %v", x.RequestTimeout, err)
+	}
+	if errors.Is(err, LNProxyError) {
+		t.Error("Timeout must not be reported as LNProxyError")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Request took %s despite the timeout", elapsed)
+	}
+
+	// Timeout of the embedded http.Client
+	y := NewLNProxy(*serverURL, 1000, 500).WithLogger(logger).WithClock(testNow)
+	y.Client.Timeout = 50 * time.Millisecond
+	_, err = y.RequestProxy(invoice, 1500)
+	if !errors.As(err, &timeoutErr) || timeoutErr.Limit != y.Client.Timeout || !DefaultRetryPolicy.retryable(err) {
+		t.Fatalf("Expected retryable TimeoutError after %s, got %v", y.Client.Timeout, err)
+	}
+
+	// Deadline on the caller's context
+	x.RequestTimeout = time.Minute
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = x.RequestProxyContext(ctx, invoice, 1500)
+	if !errors.As(err, &timeoutErr) || timeoutErr.Limit != 0 || !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Expected TimeoutError from the context deadline, got %v", err)
+	}
+
+	// Cancellation is not a timeout
+	ctx, cancel = context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		cancel()
+	}()
+	_, err = x.RequestProxyContext(ctx, invoice, 1500)
+	if !errors.Is(err, context.Canceled) || errors.As(err, &timeoutErr) {
+		t.Fatalf("Expected context.Canceled, got %v", err)
+	}
+}
+
+func TestRequestProxyOversizedResponse(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// A hostile relay streaming an endless proxy invoice
+		w.Write([]byte(`{"proxy_invoice": "lnbc`))
+		chunk := bytes.Repeat([]byte{'q'}, 64<<10)
+		for i := 0; i < 64; i++ {
+			if _, err := w.Write(chunk); err != nil {
+				return
+			}
+		}
+	}))
+	defer server.Close()
+
+	_, err := newTestClient(server.URL).RequestProxy("test-invoice", 1500)
+	if err == nil || !strings.Contains(err.Error(), "larger than") {
+		t.Errorf("Expected the oversized response to be refused, got %v", err)
+	}
+}
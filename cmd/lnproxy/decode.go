@@ -0,0 +1,215 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	client "github.com/lnproxy/lnproxy-client"
+)
+
+// featureNames names the BOLT 9 feature pairs found in invoices, by their
+// even bit
+var featureNames = map[int]string{
+	8:  "var_onion_optin",
+	14: "payment_secret",
+	16: "basic_mpp",
+	24: "option_route_blinding",
+	48: "option_payment_metadata",
+}
+
+// decodeOutput is the JSON output of the decode command
+type decodeOutput struct {
+	Network            string         `json:"network"`
+	AmountMsat         uint64         `json:"amount_msat"`
+	AmountSat          string         `json:"amount_sat"`
+	AmountBTC          string         `json:"amount_btc"`
+	Timestamp          time.Time      `json:"timestamp"`
+	ExpirySeconds      int64          `json:"expiry_seconds"`
+	ExpiresAt          time.Time      `json:"expires_at"`
+	PaymentHash        string         `json:"payment_hash"`
+	PaymentSecret      string         `json:"payment_secret,omitempty"`
+	Description        *string        `json:"description,omitempty"`
+	DescriptionHash    string         `json:"description_hash,omitempty"`
+	MinFinalCLTVExpiry uint64         `json:"min_final_cltv_expiry"`
+	Payee              string         `json:"payee"`
+	Features           []featureJSON  `json:"features"`
+	RouteHints         [][]hopJSON    `json:"route_hints,omitempty"`
+	FallbackAddresses  []fallbackJSON `json:"fallback_addresses,omitempty"`
+	Metadata           string         `json:"metadata,omitempty"`
+}
+
+type featureJSON struct {
+	Bit      int    `json:"bit"`
+	Name     string `json:"name,omitempty"`
+	Required bool   `json:"required"`
+}
+
+type hopJSON struct {
+	PubKey                    string `json:"pubkey"`
+	ShortChannelID            string `json:"short_channel_id"`
+	FeeBaseMsat               uint32 `json:"fee_base_msat"`
+	FeeProportionalMillionths uint32 `json:"fee_proportional_millionths"`
+	CLTVExpiryDelta           uint16 `json:"cltv_expiry_delta"`
+}
+
+type fallbackJSON struct {
+	Version byte   `json:"version"`
+	Program string `json:"program"`
+}
+
+// decode prints every field of an invoice, decoded locally
+func decode(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	fs := newFlagSet("decode", stderr)
+	jsonOut := fs.Bool("json", false, "print JSON instead of a table")
+	logLevel := fs.String("log-level", "warn", "log `level`: error, warn, info or debug")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: lnproxy decode [flags] [invoice]\n\nFlags:\n")
+		fs.PrintDefaults()
+	}
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+	if _, err := newLogger(*logLevel, stderr); err != nil {
+		fmt.Fprintf(stderr, "lnproxy: %v\n", err)
+		return exitUsage
+	}
+	invoice, err := readInvoice(fs.Args(), stdin)
+	if err != nil {
+		fmt.Fprintf(stderr, "lnproxy: %v\n", err)
+		return exitUsage
+	}
+
+	inv, err := client.DecodeInvoice([]byte(invoice))
+	if err != nil {
+		fmt.Fprintf(stderr, "lnproxy: cannot decode invoice: %v\n", err)
+		return exitFailure
+	}
+	out := newDecodeOutput(inv)
+
+	if *jsonOut {
+		enc := json.NewEncoder(stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(out)
+		return exitOK
+	}
+	printInvoice(stdout, out)
+	return exitOK
+}
+
+func newDecodeOutput(inv *client.Invoice) *decodeOutput {
+	out := &decodeOutput{
+		Network:            inv.Network.String(),
+		AmountMsat:         inv.AmountMsat,
+		AmountSat:          formatDecimal(inv.AmountMsat, 3),
+		AmountBTC:          formatDecimal(inv.AmountMsat, 11),
+		Timestamp:          inv.Timestamp.UTC(),
+		ExpirySeconds:      int64(inv.Expiry / time.Second),
+		ExpiresAt:          inv.Timestamp.Add(inv.Expiry).UTC(),
+		PaymentHash:        hex.EncodeToString(inv.PaymentHash),
+		PaymentSecret:      hex.EncodeToString(inv.PaymentSecret),
+		DescriptionHash:    hex.EncodeToString(inv.DescriptionHash),
+		MinFinalCLTVExpiry: inv.MinFinalCLTVExpiry,
+		Payee:              hex.EncodeToString(inv.Payee),
+		Features:           []featureJSON{},
+		Metadata:           hex.EncodeToString(inv.Metadata),
+	}
+	if inv.DescriptionHash == nil {
+		out.Description = &inv.Description
+	}
+	for _, bit := range inv.Features {
+		out.Features = append(out.Features, featureJSON{Bit: bit, Name: featureNames[bit&^1], Required: bit%2 == 0})
+	}
+	for _, route := range inv.RouteHints {
+		hops := make([]hopJSON, len(route))
+		for i, hop := range route {
+			hops[i] = hopJSON{
+				PubKey:                    hex.EncodeToString(hop.PubKey),
+				ShortChannelID:            formatShortChannelID(hop.ShortChannelID),
+				FeeBaseMsat:               hop.FeeBaseMsat,
+				FeeProportionalMillionths: hop.FeeProportionalMillionths,
+				CLTVExpiryDelta:           hop.CLTVExpiryDelta,
+			}
+		}
+		out.RouteHints = append(out.RouteHints, hops)
+	}
+	for _, f := range inv.FallbackAddresses {
+		out.FallbackAddresses = append(out.FallbackAddresses, fallbackJSON{Version: f.Version, Program: hex.EncodeToString(f.Program)})
+	}
+	return out
+}
+
+// printInvoice prints a decoded invoice as an aligned table
+func printInvoice(w io.Writer, out *decodeOutput) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	row := func(name, format string, args ...interface{}) {
+		fmt.Fprintf(tw, "%s:\t%s\n", name, fmt.Sprintf(format, args...))
+	}
+
+	row("network", "%s", out.Network)
+	if out.AmountMsat == 0 {
+		row("amount", "any")
+	} else {
+		row("amount", "%d msat (%s sat, %s BTC)", out.AmountMsat, out.AmountSat, out.AmountBTC)
+	}
+	row("timestamp", "%s", out.Timestamp.Format(time.RFC3339))
+	row("expiry", "%s (%s)", time.Duration(out.ExpirySeconds)*time.Second, out.ExpiresAt.Format(time.RFC3339))
+	row("payment hash", "%s", out.PaymentHash)
+	if out.PaymentSecret != "" {
+		row("payment secret", "%s", out.PaymentSecret)
+	}
+	if out.Description != nil {
+		row("description", "%s", strconv.Quote(*out.Description))
+	} else {
+		row("description hash", "%s", out.DescriptionHash)
+	}
+	row("min final cltv", "%d", out.MinFinalCLTVExpiry)
+	row("payee", "%s", out.Payee)
+	for _, f := range out.Features {
+		kind := "optional"
+		if f.Required {
+			kind = "required"
+		}
+		name := f.Name
+		if name == "" {
+			name = "unknown"
+		}
+		row("feature", "%d %s (%s)", f.Bit, name, kind)
+	}
+	for i, route := range out.RouteHints {
+		for j, hop := range route {
+			row(fmt.Sprintf("route %d hop %d", i+1, j+1), "%s %s fee %d msat + %d ppm, cltv delta %d",
+				hop.PubKey, hop.ShortChannelID, hop.FeeBaseMsat, hop.FeeProportionalMillionths, hop.CLTVExpiryDelta)
+		}
+	}
+	for _, f := range out.FallbackAddresses {
+		row("fallback", "version %d %s", f.Version, f.Program)
+	}
+	if out.Metadata != "" {
+		row("metadata", "%s", out.Metadata)
+	}
+	tw.Flush()
+}
+
+// formatDecimal renders n divided by 10^decimals without trailing zeros
+func formatDecimal(n uint64, decimals int) string {
+	s := strconv.FormatUint(n, 10)
+	if len(s) <= decimals {
+		s = strings.Repeat("0", decimals-len(s)+1) + s
+	}
+	whole, frac := s[:len(s)-decimals], strings.TrimRight(s[len(s)-decimals:], "0")
+	if frac == "" {
+		return whole
+	}
+	return whole + "." + frac
+}
+
+// formatShortChannelID renders a short channel ID as block x tx x output
+func formatShortChannelID(scid uint64) string {
+	return fmt.Sprintf("%dx%dx%d", scid>>40, scid>>16&0xffffff, scid&0xffff)
+}
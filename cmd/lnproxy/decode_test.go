@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	client "github.com/lnproxy/lnproxy-client"
+)
+
+func TestDecode(t *testing.T) {
+	invoice, err := client.EncodeInvoice(&client.Invoice{
+		Network:     client.Testnet,
+		AmountMsat:  2_500_000,
+		Timestamp:   time.Unix(1496314658, 0),
+		PaymentHash: bytes.Repeat([]byte{0x01}, 32),
+		Description: "1 cup coffee",
+		Expiry:      time.Minute,
+		RouteHints: []client.RouteHint{{{
+			PubKey:                    relayKey.PubKey().SerializeCompressed(),
+			ShortChannelID:            800000<<40 | 12<<16 | 1,
+			FeeBaseMsat:               1000,
+			FeeProportionalMillionths: 100,
+			CLTVExpiryDelta:           40,
+		}}},
+		Features: client.FeatureVector{8, 15, 100},
+	}, payeeKey)
+	if err != nil {
+		t.Fatalf("EncodeInvoice failed: %v", err)
+	}
+	payee := hex.EncodeToString(payeeKey.PubKey().SerializeCompressed())
+
+	code, stdout, stderr := runCommand("", "decode", invoice)
+	if code != exitOK {
+		t.Fatalf("Expected exit code 0, got %d: %s", code, stderr)
+	}
+	for _, want := range []string{
+		"network:",
+		"testnet",
+		"2500000 msat (2500 sat, 0.000025 BTC)",
+		"2017-06-01T10:57:38Z",
+		"1m0s (2017-06-01T10:58:38Z)",
+		strings.Repeat("01", 32),
+		`"1 cup coffee"`,
+		"payee:",
+		payee,
+		"8 var_onion_optin (required)",
+		"15 payment_secret (optional)",
+		"100 unknown (required)",
+		"800000x12x1 fee 1000 msat + 100 ppm, cltv delta 40",
+	} {
+		if !strings.Contains(stdout, want) {
+			t.Errorf("Expected output to contain %q, got:\n%s", want, stdout)
+		}
+	}
+
+	code, stdout, _ = runCommand(invoice+"\n", "decode", "-json")
+	var out decodeOutput
+	if err := json.Unmarshal([]byte(stdout), &out); code != exitOK || err != nil {
+		t.Fatalf("Expected JSON output, got %d %v: %s", code, err, stdout)
+	}
+	if out.Network != "testnet" || out.AmountMsat != 2_500_000 || out.AmountBTC != "0.000025" || out.Payee != payee ||
+		out.Description == nil || *out.Description != "1 cup coffee" || out.ExpirySeconds != 60 ||
+		len(out.RouteHints) != 1 || out.RouteHints[0][0].ShortChannelID != "800000x12x1" || len(out.Features) != 3 {
+		t.Errorf("Unexpected JSON output %+v", out)
+	}
+
+	if code, _, stderr := runCommand("", "decode", "lnbc1notaninvoice"); code != exitFailure || !strings.Contains(stderr, "cannot decode") {
+		t.Errorf("Expected failure for an invalid invoice, got %d: %s", code, stderr)
+	}
+}
+
+func TestFormatDecimal(t *testing.T) {
+	tests := []struct {
+		n        uint64
+		decimals int
+		want     string
+	}{
+		{0, 3, "0"},
+		{1, 3, "0.001"},
+		{1000, 3, "1"},
+		{2_500_000, 11, "0.000025"},
+		{100_000_000_000, 11, "1"},
+		{123_456_789_012, 11, "1.23456789012"},
+	}
+	for _, tt := range tests {
+		if got := formatDecimal(tt.n, tt.decimals); got != tt.want {
+			t.Errorf("formatDecimal(%d, %d) = %s, expected %s", tt.n, tt.decimals, got, tt.want)
+		}
+	}
+}
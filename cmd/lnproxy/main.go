@@ -0,0 +1,183 @@
+// Command lnproxy wraps Lightning invoices through lnproxy relays from the
+// command line.
+//
+// Usage:
+//
+//	lnproxy [wrap] [flags] [invoice]
+//	lnproxy decode [-json] [invoice]
+//	lnproxy verify [flags] [invoice proxy_invoice]
+//
+// The invoice is read from standard input when it is not given as an
+// argument. Run "lnproxy help" for the list of commands and flags.
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	client "github.com/lnproxy/lnproxy-client"
+)
+
+// Exit codes
+const (
+	exitOK      = 0
+	exitFailure = 1
+	exitUsage   = 2
+)
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+// run executes the command given by args and returns its exit code
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	if len(args) > 0 {
+		switch args[0] {
+		case "wrap":
+			return wrap(args[1:], stdin, stdout, stderr)
+		case "decode":
+			return decode(args[1:], stdin, stdout, stderr)
+		case "verify":
+			return verify(args[1:], stdin, stdout, stderr)
+		case "help", "-h", "-help", "--help":
+			usage(stdout)
+			return exitOK
+		}
+	}
+	return wrap(args, stdin, stdout, stderr)
+}
+
+func usage(w io.Writer) {
+	fmt.Fprint(w, `Usage: lnproxy [command] [flags] [invoice]
+
+Commands:
+  wrap    wrap an invoice through one or more relays (default)
+  decode  print every field of an invoice, decoded locally
+  verify  check a proxy invoice against the original invoice
+  help    show this help
+
+Run "lnproxy <command> -h" for the flags of a command.
+`)
+}
+
+// newFlagSet returns a flag set for a command that reports errors to stderr
+func newFlagSet(name string, stderr io.Writer) *flag.FlagSet {
+	fs := flag.NewFlagSet("lnproxy "+name, flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	return fs
+}
+
+// parseFlags parses args into fs, returning the exit code to stop with when
+// parsing does not succeed
+func parseFlags(fs *flag.FlagSet, args []string) (int, bool) {
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return exitOK, false
+		}
+		return exitUsage, false
+	}
+	return exitOK, true
+}
+
+// listFlag is a flag that may be repeated or given a comma-separated list
+type listFlag []string
+
+func (l *listFlag) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *listFlag) Set(value string) error {
+	for _, v := range strings.Split(value, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			*l = append(*l, v)
+		}
+	}
+	return nil
+}
+
+// readInvoices returns the n invoices given as arguments, or read from stdin
+// separated by white space when there are no arguments. lightning: URI
+// prefixes are removed.
+func readInvoices(args []string, stdin io.Reader, n int) ([]string, error) {
+	if len(args) == 0 {
+		text, err := io.ReadAll(stdin)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read invoices: %w", err)
+		}
+		args = strings.Fields(string(text))
+	}
+	if len(args) != n {
+		return nil, fmt.Errorf("expected %d invoices, got %d", n, len(args))
+	}
+	invoices := make([]string, n)
+	for i, arg := range args {
+		invoices[i] = strings.TrimPrefix(strings.ToLower(arg), "lightning:")
+	}
+	return invoices, nil
+}
+
+// readInvoice returns the invoice given as the only argument, or read from
+// stdin when there is none. A lightning: URI prefix is removed.
+func readInvoice(args []string, stdin io.Reader) (string, error) {
+	var invoice string
+	switch len(args) {
+	case 0:
+		line, err := bufio.NewReader(stdin).ReadString('\n')
+		if err != nil && err != io.EOF {
+			return "", fmt.Errorf("cannot read invoice: %w", err)
+		}
+		invoice = line
+	case 1:
+		invoice = args[0]
+	default:
+		return "", errors.New("expected a single invoice")
+	}
+	invoice = strings.ToLower(strings.TrimSpace(invoice))
+	invoice = strings.TrimPrefix(invoice, "lightning:")
+	if invoice == "" {
+		return "", errors.New("no invoice given")
+	}
+	return invoice, nil
+}
+
+// newLogger returns a logger writing to stderr at the named level, and
+// points the library's default logger there too
+func newLogger(level string, stderr io.Writer) (*client.Logger, error) {
+	var l client.LogLevel
+	switch strings.ToLower(level) {
+	case "error":
+		l = client.LevelError
+	case "warn", "warning":
+		l = client.LevelWarn
+	case "info":
+		l = client.LevelInfo
+	case "debug":
+		l = client.LevelDebug
+	default:
+		return nil, fmt.Errorf("unknown log level %q", level)
+	}
+	client.SetGlobalLevel(l)
+	client.SetGlobalOutput(stderr)
+	return client.NewLogger(l, stderr), nil
+}
+
+// parseNetworks accepts networks by name or invoice prefix
+func parseNetworks(names []string) ([]client.Network, error) {
+	var networks []client.Network
+next:
+	for _, name := range names {
+		for _, n := range []client.Network{client.Mainnet, client.Testnet, client.Signet, client.Regtest, client.Simnet} {
+			if strings.EqualFold(name, n.String()) || strings.EqualFold(name, string(n)) {
+				networks = append(networks, n)
+				continue next
+			}
+		}
+		return nil, fmt.Errorf("unknown network %q", name)
+	}
+	return networks, nil
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/decred/dcrd/dcrec/secp256k1/v4"
+	client "github.com/lnproxy/lnproxy-client"
+)
+
+var (
+	payeeKey = secp256k1.PrivKeyFromBytes(bytes.Repeat([]byte{0x11}, 32))
+	relayKey = secp256k1.PrivKeyFromBytes(bytes.Repeat([]byte{0x42}, 32))
+)
+
+// testInvoice returns an encoded mainnet invoice for 250000 sat
+func testInvoice(t *testing.T) string {
+	t.Helper()
+	invoice, err := client.EncodeInvoice(&client.Invoice{
+		AmountMsat:    250_000_000,
+		PaymentHash:   bytes.Repeat([]byte{0x01}, 32),
+		PaymentSecret: bytes.Repeat([]byte{0x02}, 32),
+		Description:   "1 cup coffee",
+		Expiry:        time.Hour,
+		Features:      client.FeatureVector{8, 14},
+	}, payeeKey)
+	if err != nil {
+		t.Fatalf("EncodeInvoice failed: %v", err)
+	}
+	return invoice
+}
+
+// newRelay starts an honest relay that adds extraMsat to the requested
+// routing budget, so that a non-zero extraMsat makes it cheat
+func newRelay(t *testing.T, extraMsat uint64) string {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Invoice     string `json:"invoice"`
+			RoutingMsat uint64 `json:"routing_msat,string"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		proxy, err := client.DecodeInvoice([]byte(req.Invoice))
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"status": "ERROR", "reason": "invalid invoice"})
+			return
+		}
+		proxy.AmountMsat += req.RoutingMsat + extraMsat
+		proxy.PaymentSecret = bytes.Repeat([]byte{0x03}, 32)
+		proxy_invoice, _ := client.EncodeInvoice(proxy, relayKey)
+		json.NewEncoder(w).Encode(map[string]string{"proxy_invoice": proxy_invoice})
+	}))
+	t.Cleanup(server.Close)
+	return server.URL
+}
+
+// runCommand runs the command line with stdin and returns its exit code,
+// stdout and stderr
+func runCommand(stdin string, args ...string) (int, string, string) {
+	var stdout, stderr bytes.Buffer
+	code := run(args, strings.NewReader(stdin), &stdout, &stderr)
+	return code, stdout.String(), stderr.String()
+}
+
+func TestWrap(t *testing.T) {
+	invoice := testInvoice(t)
+	relay := newRelay(t, 0)
+
+	code, stdout, stderr := runCommand("", "-relay", relay, "-routing-msat", "1000", invoice)
+	if code != exitOK {
+		t.Fatalf("Expected exit code 0, got %d: %s", code, stderr)
+	}
+	proxyInvoice := strings.TrimSpace(stdout)
+	if ok, err := client.ValidateProxyInvoice(invoice, proxyInvoice, 1000); !ok {
+		t.Errorf("Printed proxy invoice does not validate: %v", err)
+	}
+
+	// Invoice on stdin, with a URI prefix and upper case
+	code, stdout, stderr = runCommand("lightning:"+strings.ToUpper(invoice)+"\n", "wrap", "-relay", relay, "-base-msat", "1000")
+	if code != exitOK || !strings.HasPrefix(stdout, "lnbc") {
+		t.Errorf("Expected proxy invoice from stdin, got %d: %s%s", code, stdout, stderr)
+	}
+
+	// Budget chosen by the relay, within a ceiling
+	choosing := newRelay(t, 5000)
+	if code, _, stderr := runCommand("", "-relay", choosing, "-max-budget-msat", "10000", invoice); code != exitOK {
+		t.Errorf("Expected relay-chosen budget under the ceiling, got %d: %s", code, stderr)
+	}
+	if code, _, stderr := runCommand("", "-relay", choosing, "-max-budget-ppm", "10", invoice); code != exitFailure || !strings.Contains(stderr, "fee policy") {
+		t.Errorf("Expected failure above the ceiling, got %d: %s", code, stderr)
+	}
+}
+
+func TestWrapJSONFailover(t *testing.T) {
+	invoice := testInvoice(t)
+	cheating, honest := newRelay(t, 5000), newRelay(t, 0)
+
+	code, stdout, stderr := runCommand("", "-json", "-relay", cheating+","+honest, "-routing-msat", "1000", invoice)
+	if code != exitOK {
+		t.Fatalf("Expected exit code 0, got %d: %s", code, stderr)
+	}
+	var out wrapOutput
+	if err := json.Unmarshal([]byte(stdout), &out); err != nil {
+		t.Fatalf("Cannot parse JSON output %q: %v", stdout, err)
+	}
+	if out.Relay != honest || out.RoutingMsat != 1000 || len(out.Skipped) != 1 || out.Skipped[0].Relay != cheating {
+		t.Errorf("Unexpected output %+v", out)
+	}
+
+	// No relay serves a valid proxy invoice
+	code, _, stderr = runCommand("", "-relay", cheating, "-routing-msat", "1000", invoice)
+	if code != exitFailure || !strings.Contains(stderr, "routing budget") {
+		t.Errorf("Expected failure naming the budget mismatch, got %d: %s", code, stderr)
+	}
+}
+
+func TestWrapUsage(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+	}{
+		{"no relay", []string{"lnbc1"}},
+		{"no invoice", []string{"-relay", "http://127.0.0.1:1", "-routing-msat", "1000"}},
+		{"unbounded relay budget", []string{"-relay", "http://127.0.0.1:1", "lnbc1"}},
+		{"bad log level", []string{"-relay", "http://127.0.0.1:1", "-log-level", "loud", "lnbc1"}},
+		{"bad network", []string{"-relay", "http://127.0.0.1:1", "-network", "moon", "lnbc1"}},
+		{"bad flag", []string{"-frobnicate"}},
+	}
+	for _, tt := range tests {
+		if code, _, _ := runCommand("", tt.args...); code != exitUsage {
+			t.Errorf("%s: expected exit code %d, got %d", tt.name, exitUsage, code)
+		}
+	}
+	if code, stdout, _ := runCommand("", "help"); code != exitOK || !strings.Contains(stdout, "Usage") {
+		t.Errorf("Expected usage on stdout, got %d: %s", code, stdout)
+	}
+}
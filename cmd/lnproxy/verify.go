@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"text/tabwriter"
+
+	client "github.com/lnproxy/lnproxy-client"
+)
+
+// verifyOutput is the JSON output of the verify command
+type verifyOutput struct {
+	OK     bool        `json:"ok"`
+	Checks []checkJSON `json:"checks"`
+}
+
+type checkJSON struct {
+	Name     string `json:"name"`
+	Status   string `json:"status"`
+	Expected string `json:"expected,omitempty"`
+	Actual   string `json:"actual,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// verify audits a proxy invoice against the original and prints every check.
+// It fails when any check does.
+func verify(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	fs := newFlagSet("verify", stderr)
+	var networks listFlag
+	routingMsat := fs.Uint64("routing-msat", 0, "expected routing budget in msat; 0 applies -base-msat and -ppm")
+	baseMsat := fs.Uint64("base-msat", 0, "base routing budget in msat")
+	ppm := fs.Uint64("ppm", 0, "proportional routing budget in millionths of the amount")
+	description := fs.String("description", "", "description the proxy invoice was requested with")
+	descriptionHash := fs.String("description-hash", "", "description `hash`, in hex, the proxy invoice was requested with")
+	fs.Var(&networks, "network", "accepted invoice `networks` (default mainnet)")
+	logLevel := fs.String("log-level", "warn", "log `level`: error, warn, info or debug")
+	jsonOut := fs.Bool("json", false, "print JSON instead of a table")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: lnproxy verify [flags] [invoice proxy_invoice]\n\nFlags:\n")
+		fs.PrintDefaults()
+	}
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+
+	if *routingMsat == 0 && *baseMsat == 0 && *ppm == 0 {
+		fmt.Fprintln(stderr, "lnproxy: an expected budget is required, set -routing-msat or -base-msat and -ppm")
+		return exitUsage
+	}
+	if _, err := newLogger(*logLevel, stderr); err != nil {
+		fmt.Fprintf(stderr, "lnproxy: %v\n", err)
+		return exitUsage
+	}
+	accepted, err := parseNetworks(networks)
+	if err != nil {
+		fmt.Fprintf(stderr, "lnproxy: %v\n", err)
+		return exitUsage
+	}
+	opts := client.RequestOptions{Description: *description}
+	if *descriptionHash != "" {
+		if opts.DescriptionHash, err = hex.DecodeString(*descriptionHash); err != nil || len(opts.DescriptionHash) != 32 {
+			fmt.Fprintln(stderr, "lnproxy: -description-hash must be 32 bytes of hex")
+			return exitUsage
+		}
+	}
+	invoices, err := readInvoices(fs.Args(), stdin, 2)
+	if err != nil {
+		fmt.Fprintf(stderr, "lnproxy: %v\n", err)
+		return exitUsage
+	}
+
+	x := client.NewLNProxy(url.URL{}, *baseMsat, *ppm).WithNetworks(accepted...)
+	report := x.AuditProxyInvoiceWithOptions(invoices[0], invoices[1], *routingMsat, opts)
+
+	out := verifyOutput{OK: report.OK(), Checks: []checkJSON{}}
+	for _, c := range report.Checks {
+		check := checkJSON{Name: c.Name, Status: c.Status.String(), Expected: c.Expected, Actual: c.Actual}
+		if c.Err != nil {
+			check.Error = c.Err.Error()
+		}
+		out.Checks = append(out.Checks, check)
+	}
+	if *jsonOut {
+		enc := json.NewEncoder(stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(out)
+	} else {
+		printChecks(stdout, out)
+	}
+	if !out.OK {
+		return exitFailure
+	}
+	return exitOK
+}
+
+// printChecks prints one line per check, with the reason for a failed or
+// skipped one
+func printChecks(w io.Writer, out verifyOutput) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "STATUS\tCHECK\tEXPECTED\tACTUAL\tREASON")
+	for _, c := range out.Checks {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", c.Status, c.Name, c.Expected, c.Actual, c.Error)
+	}
+	tw.Flush()
+	if out.OK {
+		fmt.Fprintln(w, "proxy invoice is valid")
+	} else {
+		fmt.Fprintln(w, "proxy invoice is NOT valid")
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	client "github.com/lnproxy/lnproxy-client"
+)
+
+// proxyFor returns a proxy invoice for invoice with the given routing
+// budget, signed by the relay
+func proxyFor(t *testing.T, invoice string, routingMsat uint64) string {
+	t.Helper()
+	proxy, err := client.DecodeInvoice([]byte(invoice))
+	if err != nil {
+		t.Fatalf("DecodeInvoice failed: %v", err)
+	}
+	proxy.AmountMsat += routingMsat
+	proxy.PaymentSecret = bytes.Repeat([]byte{0x03}, 32)
+	proxyInvoice, err := client.EncodeInvoice(proxy, relayKey)
+	if err != nil {
+		t.Fatalf("EncodeInvoice failed: %v", err)
+	}
+	return proxyInvoice
+}
+
+func TestVerify(t *testing.T) {
+	invoice := testInvoice(t)
+	proxyInvoice := proxyFor(t, invoice, 1000)
+
+	code, stdout, stderr := runCommand("", "verify", "-routing-msat", "1000", invoice, proxyInvoice)
+	if code != exitOK {
+		t.Fatalf("Expected exit code 0, got %d: %s%s", code, stdout, stderr)
+	}
+	for _, want := range []string{"PASS    payment_hash", "PASS    amount", "250001000 msat", "proxy invoice is valid"} {
+		if !strings.Contains(stdout, want) {
+			t.Errorf("Expected output to contain %q, got:\n%s", want, stdout)
+		}
+	}
+
+	// The relay took more than the expected budget
+	code, stdout, _ = runCommand("", "verify", "-routing-msat", "500", invoice, proxyInvoice)
+	if code != exitFailure {
+		t.Errorf("Expected exit code 1, got %d", code)
+	}
+	for _, want := range []string{"FAIL    amount", "250000500 msat", "250001000 msat", "routing budget not respected", "NOT valid"} {
+		if !strings.Contains(stdout, want) {
+			t.Errorf("Expected output to contain %q, got:\n%s", want, stdout)
+		}
+	}
+
+	// JSON, with both invoices on stdin and a fee policy as the budget
+	code, stdout, _ = runCommand(invoice+"\n"+proxyInvoice+"\n", "verify", "-json", "-base-msat", "1000")
+	var out verifyOutput
+	if err := json.Unmarshal([]byte(stdout), &out); err != nil || code != exitOK || !out.OK {
+		t.Fatalf("Expected valid JSON report, got %d %v: %s", code, err, stdout)
+	}
+	for _, c := range out.Checks {
+		if c.Status == "FAIL" {
+			t.Errorf("Unexpected failed check %+v", c)
+		}
+	}
+}
+
+func TestVerifyUsage(t *testing.T) {
+	invoice := testInvoice(t)
+	tests := []struct {
+		name string
+		args []string
+	}{
+		{"no budget", []string{"verify", invoice, invoice}},
+		{"one invoice", []string{"verify", "-routing-msat", "1000", invoice}},
+		{"bad description hash", []string{"verify", "-routing-msat", "1000", "-description-hash", "abcd", invoice, invoice}},
+	}
+	for _, tt := range tests {
+		if code, _, _ := runCommand("", tt.args...); code != exitUsage {
+			t.Errorf("%s: expected exit code %d, got %d", tt.name, exitUsage, code)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"time"
+
+	client "github.com/lnproxy/lnproxy-client"
+)
+
+// wrapOutput is the JSON output of the wrap command
+type wrapOutput struct {
+	ProxyInvoice string        `json:"proxy_invoice"`
+	Relay        string        `json:"relay"`
+	RoutingMsat  uint64        `json:"routing_msat"`
+	Skipped      []skippedJSON `json:"skipped,omitempty"`
+}
+
+type skippedJSON struct {
+	Relay string `json:"relay"`
+	Error string `json:"error"`
+}
+
+// wrap requests a proxy invoice through the configured relays, tried in
+// order, and prints it once it validates
+func wrap(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	fs := newFlagSet("wrap", stderr)
+	var relays, networks listFlag
+	fs.Var(&relays, "relay", "relay `URL`, repeat or separate with commas to fail over between relays")
+	routingMsat := fs.Uint64("routing-msat", 0, "routing budget in msat; 0 applies -base-msat and -ppm, or lets the relay choose up to -max-budget-msat and -max-budget-ppm when both are 0")
+	baseMsat := fs.Uint64("base-msat", 0, "base routing budget in msat")
+	ppm := fs.Uint64("ppm", 0, "proportional routing budget in millionths of the amount")
+	maxBudgetMsat := fs.Uint64("max-budget-msat", 0, "largest routing budget in msat a relay may choose")
+	maxBudgetPpm := fs.Uint64("max-budget-ppm", 0, "largest routing budget a relay may choose, in millionths of the amount")
+	amountMsat := fs.Uint64("amount-msat", 0, "expected payment in msat for amountless invoices, which are refused when 0")
+	socks := fs.String("socks", "", "SOCKS5 proxy `address`, such as 127.0.0.1:9050 for Tor")
+	isolate := fs.Bool("isolate", true, "use a separate Tor circuit for every request (with -socks)")
+	timeout := fs.Duration("timeout", 30*time.Second, "timeout of each request to a relay")
+	minRemaining := fs.Duration("min-remaining", client.DefaultMinRemaining, "lifetime an invoice must have left to be wrapped")
+	fs.Var(&networks, "network", "accepted invoice `networks` (default mainnet)")
+	logLevel := fs.String("log-level", "warn", "log `level`: error, warn, info or debug")
+	jsonOut := fs.Bool("json", false, "print JSON instead of the bare proxy invoice")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: lnproxy wrap [flags] [invoice]\n\nFlags:\n")
+		fs.PrintDefaults()
+	}
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+
+	if len(relays) == 0 {
+		fmt.Fprintln(stderr, "lnproxy: at least one -relay is required")
+		return exitUsage
+	}
+	relayChosen := *routingMsat == 0 && *baseMsat == 0 && *ppm == 0
+	if relayChosen && *maxBudgetMsat == 0 && *maxBudgetPpm == 0 {
+		fmt.Fprintln(stderr, "lnproxy: give -routing-msat, -base-msat or -ppm, or cap the relay's choice with -max-budget-msat or -max-budget-ppm")
+		return exitUsage
+	}
+	logger, err := newLogger(*logLevel, stderr)
+	if err != nil {
+		fmt.Fprintf(stderr, "lnproxy: %v\n", err)
+		return exitUsage
+	}
+	accepted, err := parseNetworks(networks)
+	if err != nil {
+		fmt.Fprintf(stderr, "lnproxy: %v\n", err)
+		return exitUsage
+	}
+	invoice, err := readInvoice(fs.Args(), stdin)
+	if err != nil {
+		fmt.Fprintf(stderr, "lnproxy: %v\n", err)
+		return exitUsage
+	}
+
+	pool := client.NewRelayPool().WithLogger(logger)
+	for _, raw := range relays {
+		relayURL, err := url.Parse(raw)
+		if err != nil || relayURL.Host == "" {
+			fmt.Fprintf(stderr, "lnproxy: invalid relay URL %q\n", raw)
+			return exitUsage
+		}
+		relay := client.NewLNProxy(*relayURL, *baseMsat, *ppm).WithLogger(logger).WithNetworks(accepted...)
+		relay.RelayChosenBudget = relayChosen
+		relay.MaxBudgetMsat = *maxBudgetMsat
+		relay.MaxBudgetPpm = *maxBudgetPpm
+		relay.RequestTimeout = *timeout
+		relay.MinRemaining = *minRemaining
+		if *amountMsat != 0 {
+			relay.WithZeroAmount(*amountMsat)
+		}
+		if *socks != "" {
+			relay.WithSOCKS5(client.SOCKSProxy{Address: *socks, IsolateStreams: *isolate})
+		}
+		pool.Relays = append(pool.Relays, relay)
+	}
+
+	result, err := pool.RequestProxyContext(context.Background(), invoice, *routingMsat)
+	if err != nil {
+		fmt.Fprintf(stderr, "lnproxy: %v\n", err)
+		return exitFailure
+	}
+
+	if !*jsonOut {
+		fmt.Fprintln(stdout, result.ProxyInvoice)
+		return exitOK
+	}
+	out := wrapOutput{ProxyInvoice: result.ProxyInvoice, Relay: result.Relay.URL.String()}
+	if budget, err := client.ImpliedRoutingBudget(invoice, result.ProxyInvoice); err == nil {
+		out.RoutingMsat = budget
+	}
+	for _, s := range result.Skipped {
+		out.Skipped = append(out.Skipped, skippedJSON{Relay: s.Relay.URL.String(), Error: s.Err.Error()})
+	}
+	enc := json.NewEncoder(stdout)
+	enc.SetIndent("", "  ")
+	enc.Encode(out)
+	return exitOK
+}
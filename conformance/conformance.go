@@ -0,0 +1,316 @@
+// Package conformance checks that an lnproxy relay behaves as the protocol
+// and this client expect. Run wraps purpose-made invoices through the relay
+// and reports every check as a client.CheckResult.
+//
+// The invoices are signed with a throwaway key unless Config.Key is set, so
+// relays that probe the route to the payee may refuse them; point Key at a
+// real node to test such relays.
+package conformance
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+
+	"github.com/decred/dcrd/dcrec/secp256k1/v4"
+	client "github.com/lnproxy/lnproxy-client"
+)
+
+// Names of the conformance checks, in the order they run
+const (
+	CheckValidProxy           = "valid_proxy_invoice"
+	CheckHashPreserved        = "hash_preserved"
+	CheckRoutingMsatHonoured  = "routing_msat_honoured"
+	CheckDescriptionPreserved = "description_preserved"
+	CheckDescriptionHash      = "description_hash_preserved"
+	CheckRelayChosenBudget    = "relay_chosen_budget"
+	CheckErrorShape           = "error_shape"
+	CheckExpiredInvoice       = "expired_invoice_rejected"
+	CheckZeroAmountInvoice    = "zero_amount_invoice"
+)
+
+// Config sets the invoices Run wraps. Zero fields take the defaults below.
+type Config struct {
+	// Network of the test invoices, Mainnet by default.
+	Network client.Network
+	// AmountMsat of the test invoices, 50000 sat by default.
+	AmountMsat uint64
+	// RoutingMsat is the routing budget requested, 10000 msat by default.
+	RoutingMsat uint64
+	// Key signs the test invoices, a random key by default.
+	Key *secp256k1.PrivateKey
+	// Now is the clock the test invoices are dated by.
+	Now func() time.Time
+}
+
+func (c *Config) setDefaults() error {
+	if c.Network == "" {
+		c.Network = client.Mainnet
+	}
+	if c.AmountMsat == 0 {
+		c.AmountMsat = 50_000_000
+	}
+	if c.RoutingMsat == 0 {
+		c.RoutingMsat = 10_000
+	}
+	if c.Now == nil {
+		c.Now = time.Now
+	}
+	if c.Key == nil {
+		key, err := secp256k1.GeneratePrivateKey()
+		if err != nil {
+			return err
+		}
+		c.Key = key
+	}
+	return nil
+}
+
+// Report holds the outcome of every conformance check run against a relay
+type Report struct {
+	URL    string
+	Checks []client.CheckResult
+}
+
+// OK reports whether no check failed
+func (r *Report) OK() bool {
+	for _, c := range r.Checks {
+		if c.Status == client.CheckFail {
+			return false
+		}
+	}
+	return true
+}
+
+// WriteTo writes the report as a table, one line per check
+func (r *Report) WriteTo(w io.Writer) (int64, error) {
+	cw := &countWriter{w: w}
+	tw := tabwriter.NewWriter(cw, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "Relay %s\n", r.URL)
+	fmt.Fprintln(tw, "STATUS\tCHECK\tEXPECTED\tACTUAL\tREASON")
+	for _, c := range r.Checks {
+		reason := ""
+		if c.Err != nil {
+			reason = c.Err.Error()
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", c.Status, c.Name, c.Expected, c.Actual, reason)
+	}
+	if err := tw.Flush(); err != nil {
+		return cw.n, err
+	}
+	verdict := "conformant"
+	if !r.OK() {
+		verdict = "NOT conformant"
+	}
+	_, err := fmt.Fprintf(cw, "Relay is %s\n", verdict)
+	return cw.n, err
+}
+
+type countWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+func (r *Report) pass(name, expected, actual string) {
+	r.Checks = append(r.Checks, client.CheckResult{Name: name, Status: client.CheckPass, Expected: expected, Actual: actual})
+}
+
+func (r *Report) fail(name, expected, actual string, err error) {
+	r.Checks = append(r.Checks, client.CheckResult{Name: name, Status: client.CheckFail, Expected: expected, Actual: actual, Err: err})
+}
+
+func (r *Report) skip(name, reason string) {
+	r.Checks = append(r.Checks, client.CheckResult{Name: name, Status: client.CheckSkip, Err: errors.New(reason)})
+}
+
+// Run checks the relay behind x. The client's transport, timeout and retry
+// settings are used as they are; its fee and network policy are not, since
+// the checks concern the relay's behaviour rather than the client's limits.
+// Amountless invoices are sent whatever the client's ZeroAmount policy, and
+// the client's clock is set to Config.Now.
+func Run(ctx context.Context, x *client.LNProxy, cfg Config) (*Report, error) {
+	if err := cfg.setDefaults(); err != nil {
+		return nil, err
+	}
+	report := &Report{URL: x.URL.String()}
+	relay := *x
+	relay.Networks = []client.Network{cfg.Network}
+	relay.ZeroAmount = client.AllowZeroAmount
+	relay.Now = cfg.Now
+
+	// An ordinary invoice with the requested budget
+	invoice, err := cfg.invoice(cfg.AmountMsat, time.Hour, func(inv *client.Invoice) {
+		inv.Description = "lnproxy conformance check"
+	})
+	if err != nil {
+		return nil, err
+	}
+	proxyInvoice, err := relay.RequestProxyContext(ctx, invoice, cfg.RoutingMsat)
+	if err != nil {
+		for _, name := range []string{CheckValidProxy, CheckHashPreserved, CheckRoutingMsatHonoured, CheckDescriptionPreserved} {
+			report.fail(name, "proxy invoice", "error", err)
+		}
+	} else {
+		audit := client.AuditProxyInvoice(invoice, proxyInvoice, cfg.RoutingMsat)
+		if audit.OK() {
+			report.pass(CheckValidProxy, "valid proxy invoice", "valid proxy invoice")
+		} else {
+			report.fail(CheckValidProxy, "valid proxy invoice", "invalid proxy invoice", audit.Err())
+		}
+		report.copy(audit, client.CheckPaymentHash, CheckHashPreserved)
+		report.copy(audit, client.CheckAmount, CheckRoutingMsatHonoured)
+		report.copy(audit, client.CheckDescription, CheckDescriptionPreserved)
+	}
+
+	// Description hash
+	hashed, err := cfg.invoice(cfg.AmountMsat, time.Hour, func(inv *client.Invoice) {
+		inv.DescriptionHash = randomBytes(32)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if proxyInvoice, err := relay.RequestProxyContext(ctx, hashed, cfg.RoutingMsat); err != nil {
+		report.fail(CheckDescriptionHash, "proxy invoice", "error", err)
+	} else {
+		report.copy(client.AuditProxyInvoice(hashed, proxyInvoice, cfg.RoutingMsat), client.CheckDescription, CheckDescriptionHash)
+	}
+
+	// Budget left to the relay
+	chosen := relay
+	chosen.RelayChosenBudget = true
+	// The relay's choice is reported rather than judged, so any budget up to
+	// the invoice amount is accepted
+	chosen.MaxBudgetMsat, chosen.MaxBudgetPpm = 0, 1_000_000
+	if proxyInvoice, err := chosen.RequestProxyContext(ctx, invoice, 0); err != nil {
+		var relayErr *client.RelayError
+		if errors.As(err, &relayErr) && relayErr.Status == "ERROR" {
+			report.skip(CheckRelayChosenBudget, "relay requires routing_msat: "+relayErr.Reason)
+		} else if errors.Is(err, client.OutsideRelayLimits) {
+			report.skip(CheckRelayChosenBudget, "refused before sending: "+err.Error())
+		} else {
+			report.fail(CheckRelayChosenBudget, "proxy invoice or error response", "error", err)
+		}
+	} else {
+		budget, err := client.ImpliedRoutingBudget(invoice, proxyInvoice)
+		hash, _ := client.AuditProxyInvoice(invoice, proxyInvoice, budget).Check(client.CheckPaymentHash)
+		switch {
+		case err != nil:
+			report.fail(CheckRelayChosenBudget, "budget", "none", err)
+		case hash.Status != client.CheckPass:
+			report.fail(CheckRelayChosenBudget, hash.Expected, hash.Actual, hash.Err)
+		default:
+			report.pass(CheckRelayChosenBudget, "proxy amount above original", fmt.Sprintf("%d msat budget", budget))
+		}
+	}
+
+	// Error responses. The garbage invoice has no network prefix, so the
+	// client passes it on to the relay.
+	_, err = relay.RequestProxyContext(ctx, "conformance-check-not-an-invoice", cfg.RoutingMsat)
+	report.checkRejected(CheckErrorShape, err, nil)
+
+	expired, err := cfg.invoice(cfg.AmountMsat, time.Hour, func(inv *client.Invoice) {
+		inv.Timestamp = cfg.Now().Add(-2 * time.Hour)
+	})
+	if err != nil {
+		return nil, err
+	}
+	// The client refuses expired invoices itself, so its clock is set back
+	// to when the invoice was fresh for the request to reach the relay
+	stale := relay
+	stale.Now = func() time.Time { return cfg.Now().Add(-2 * time.Hour) }
+	_, err = stale.RequestProxyContext(ctx, expired, cfg.RoutingMsat)
+	report.checkRejected(CheckExpiredInvoice, err, client.InvoiceExpired)
+
+	// An amountless invoice may be refused, or wrapped without an amount
+	amountless, err := cfg.invoice(0, time.Hour, nil)
+	if err != nil {
+		return nil, err
+	}
+	if proxyInvoice, err := relay.RequestProxyContext(ctx, amountless, cfg.RoutingMsat); err != nil {
+		report.checkRejected(CheckZeroAmountInvoice, err, nil)
+	} else {
+		audit := client.AuditProxyInvoice(amountless, proxyInvoice, 0)
+		amount, _ := audit.Check(client.CheckAmount)
+		hash, _ := audit.Check(client.CheckPaymentHash)
+		payee, _ := audit.Check(client.CheckPayee)
+		switch {
+		case audit.Proxy == nil:
+			report.fail(CheckZeroAmountInvoice, "valid proxy invoice", "invalid", audit.FirstError())
+		case amount.Status != client.CheckPass:
+			report.fail(CheckZeroAmountInvoice, amount.Expected, amount.Actual, amount.Err)
+		case hash.Status != client.CheckPass:
+			report.fail(CheckZeroAmountInvoice, hash.Expected, hash.Actual, hash.Err)
+		case payee.Status != client.CheckPass:
+			report.fail(CheckZeroAmountInvoice, payee.Expected, payee.Actual, payee.Err)
+		default:
+			report.pass(CheckZeroAmountInvoice, "rejection or amountless proxy invoice", "amountless proxy invoice")
+		}
+	}
+
+	return report, nil
+}
+
+// checkRejected records whether the relay refused a request with a well
+// formed error response, of the given class when class is not nil
+func (r *Report) checkRejected(name string, err error, class error) {
+	expected := `{"status": "ERROR", "reason": ...}`
+	var relayErr *client.RelayError
+	switch {
+	case err == nil:
+		r.fail(name, expected, "proxy invoice", errors.New("relay accepted the request"))
+	case !errors.As(err, &relayErr):
+		r.fail(name, expected, "no response", err)
+	case relayErr.Status != "ERROR" || relayErr.Reason == "":
+		r.fail(name, expected, fmt.Sprintf("HTTP %d, status %q, reason %q", relayErr.HTTPStatus, relayErr.Status, relayErr.Reason), err)
+	case class != nil && !errors.Is(err, class):
+		r.fail(name, class.Error(), relayErr.Reason, err)
+	default:
+		r.pass(name, expected, fmt.Sprintf("HTTP %d: %s", relayErr.HTTPStatus, relayErr.Reason))
+	}
+}
+
+// copy records the named check of audit under a conformance check name
+func (r *Report) copy(audit *client.ValidationReport, check, name string) {
+	c, ok := audit.Check(check)
+	if !ok {
+		r.skip(name, "not checked")
+		return
+	}
+	c.Name = name
+	r.Checks = append(r.Checks, c)
+}
+
+// invoice returns a fresh test invoice, adjusted by edit when not nil
+func (c *Config) invoice(amountMsat uint64, expiry time.Duration, edit func(*client.Invoice)) (string, error) {
+	inv := &client.Invoice{
+		Network:       c.Network,
+		AmountMsat:    amountMsat,
+		Timestamp:     c.Now(),
+		PaymentHash:   randomBytes(32),
+		PaymentSecret: randomBytes(32),
+		Expiry:        expiry,
+		Features:      client.FeatureVector{8, 14},
+	}
+	if edit != nil {
+		edit(inv)
+	}
+	return client.EncodeInvoice(inv, c.Key)
+}
+
+func randomBytes(n int) []byte {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		panic(fmt.Sprintf("conformance: cannot read random bytes: %v", err))
+	}
+	return b
+}
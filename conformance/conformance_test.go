@@ -0,0 +1,122 @@
+package conformance
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	client "github.com/lnproxy/lnproxy-client"
+	"github.com/lnproxy/lnproxy-client/relaytest"
+)
+
+var now = time.Unix(1700000000, 0)
+
+// newClient returns a quiet client for the relay at rawURL
+func newClient(t *testing.T, rawURL string) *client.LNProxy {
+	t.Helper()
+	serverURL, _ := url.Parse(rawURL)
+	return client.NewLNProxy(*serverURL, 0, 0).WithLogger(client.NewLogger(client.LevelError, io.Discard))
+}
+
+// run checks a relaytest relay with the given faults
+func run(t *testing.T, faults relaytest.Faults) *Report {
+	t.Helper()
+	client.SetGlobalOutput(io.Discard)
+	relay, server := relaytest.NewServer()
+	relay.Now = func() time.Time { return now }
+	relay.SetFaults(faults)
+	t.Cleanup(server.Close)
+
+	report, err := Run(context.Background(), newClient(t, server.URL), Config{Now: func() time.Time { return now }})
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	return report
+}
+
+func statuses(report *Report) map[string]client.CheckStatus {
+	got := make(map[string]client.CheckStatus)
+	for _, c := range report.Checks {
+		got[c.Name] = c.Status
+	}
+	return got
+}
+
+func TestRunHonestRelay(t *testing.T) {
+	report := run(t, relaytest.Faults{})
+	if !report.OK() {
+		var b bytes.Buffer
+		report.WriteTo(&b)
+		t.Fatalf("Expected an honest relay to conform:\n%s", b.String())
+	}
+	got := statuses(report)
+	for _, name := range []string{CheckValidProxy, CheckHashPreserved, CheckRoutingMsatHonoured, CheckDescriptionPreserved,
+		CheckDescriptionHash, CheckRelayChosenBudget, CheckErrorShape, CheckExpiredInvoice, CheckZeroAmountInvoice} {
+		if got[name] != client.CheckPass {
+			t.Errorf("Expected %s to pass, got %s", name, got[name])
+		}
+	}
+
+	var b bytes.Buffer
+	if _, err := report.WriteTo(&b); err != nil {
+		t.Fatalf("WriteTo failed: %v", err)
+	}
+	if !strings.Contains(b.String(), "Relay is conformant") || !strings.Contains(b.String(), CheckHashPreserved) {
+		t.Errorf("Unexpected report:\n%s", b.String())
+	}
+}
+
+func TestRunFaultyRelays(t *testing.T) {
+	tests := []struct {
+		name   string
+		faults relaytest.Faults
+		failed []string
+	}{
+		{"wrong hash", relaytest.Faults{WrongHash: true}, []string{CheckValidProxy, CheckHashPreserved}},
+		{"altered description", relaytest.Faults{AlterDescription: true}, []string{CheckValidProxy, CheckDescriptionPreserved, CheckDescriptionHash}},
+		{"skimmed budget", relaytest.Faults{SkimMsat: 1000}, []string{CheckValidProxy, CheckRoutingMsatHonoured}},
+		{"extended expiry", relaytest.Faults{ExtendExpiry: time.Hour}, []string{CheckValidProxy}},
+		{"unavailable", relaytest.Faults{FailStatus: http.StatusServiceUnavailable, FailReason: "maintenance"},
+			[]string{CheckValidProxy, CheckHashPreserved, CheckDescriptionHash}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			report := run(t, tt.faults)
+			if report.OK() {
+				t.Fatal("Expected the relay not to conform")
+			}
+			got := statuses(report)
+			for _, name := range tt.failed {
+				if got[name] != client.CheckFail {
+					t.Errorf("Expected %s to fail, got %s", name, got[name])
+				}
+			}
+		})
+	}
+}
+
+func TestRunMalformedErrors(t *testing.T) {
+	client.SetGlobalOutput(io.Discard)
+	// A relay that wraps nothing and answers every request in plain text
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "something went wrong", http.StatusBadRequest)
+	}))
+	defer server.Close()
+
+	report, err := Run(context.Background(), newClient(t, server.URL), Config{})
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	got := statuses(report)
+	for _, name := range []string{CheckErrorShape, CheckExpiredInvoice, CheckZeroAmountInvoice, CheckValidProxy} {
+		if got[name] != client.CheckFail {
+			t.Errorf("Expected %s to fail, got %s", name, got[name])
+		}
+	}
+}
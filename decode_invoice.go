@@ -0,0 +1,259 @@
+package client
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+)
+
+const (
+	// DefaultExpiry applies when an invoice carries no x field.
+	DefaultExpiry = time.Hour
+	// DefaultMinFinalCLTVExpiry applies when an invoice carries no c field.
+	DefaultMinFinalCLTVExpiry = 18
+)
+
+const (
+	timestampWords = 7
+	signatureWords = 104
+	checksumWords  = 6
+	hopHintLength  = 51
+)
+
+// Invoice is a BOLT11 invoice with every tagged field decoded. Fields absent
+// from the invoice are left empty, except Expiry and MinFinalCLTVExpiry
+// which take their BOLT11 defaults.
+type Invoice struct {
+	Network            Network
+	AmountMsat         uint64
+	Timestamp          time.Time
+	PaymentHash        []byte
+	PaymentSecret      []byte
+	Description        string
+	DescriptionHash    []byte
+	Expiry             time.Duration
+	MinFinalCLTVExpiry uint64
+	FallbackAddresses  []FallbackAddress
+	RouteHints         []RouteHint
+	Features           FeatureVector
+	// Payee is the payee node ID, taken from the n field when present and
+	// otherwise recovered from the signature. Either way the signature has
+	// been verified against it.
+	Payee      []byte
+	Metadata   []byte
+	Signature  []byte
+	RecoveryID byte
+}
+
+// FallbackAddress is an on-chain address from an f field, given as a
+// witness version (or 17 for P2PKH, 18 for P2SH) and its program or hash.
+type FallbackAddress struct {
+	Version byte
+	Program []byte
+}
+
+// HopHint is one hop of a private route from an r field.
+type HopHint struct {
+	PubKey                    []byte
+	ShortChannelID            uint64
+	FeeBaseMsat               uint32
+	FeeProportionalMillionths uint32
+	CLTVExpiryDelta           uint16
+}
+
+// RouteHint is a private route to the payee, in hop order.
+type RouteHint []HopHint
+
+// FeatureVector holds the feature bits set in a 9 field, in ascending order.
+type FeatureVector []int
+
+// IsSet reports whether the given feature bit is set.
+func (f FeatureVector) IsSet(bit int) bool {
+	i := sort.SearchInts(f, bit)
+	return i < len(f) && f[i] == bit
+}
+
+// DecodeInvoice parses a BOLT11 invoice, decodes all of its known tagged
+// fields and verifies its signature. Unknown fields, and p, h, s and n fields
+// of the wrong length, are skipped as BOLT11 requires.
+func DecodeInvoice(invoice []byte) (*Invoice, error) {
+	logger := DefaultLogger().WithComponent("InvoiceParser")
+	logger.Debug("Decoding invoice: %s", string(invoice[:min(len(invoice), 40)])+"...")
+
+	raw, err := splitInvoice(invoice, logger)
+	if err != nil {
+		return nil, err
+	}
+
+	inv := Invoice{
+		Network:            raw.network,
+		AmountMsat:         raw.amountMsat,
+		Timestamp:          time.Unix(int64(wordsToUint(raw.timestamp)), 0),
+		Expiry:             DefaultExpiry,
+		MinFinalCLTVExpiry: DefaultMinFinalCLTVExpiry,
+	}
+	signature := convertBits(raw.signature, 5, 8, false)
+	inv.Signature = signature[:64]
+	inv.RecoveryID = signature[64]
+	for _, f := range raw.fields {
+		if err := inv.decodeField(f.tag, f.data); err != nil {
+			logger.Error("Failed to decode field %c: %v", f.tag, err)
+			return nil, err
+		}
+	}
+
+	if err := inv.verifySignature(signingHash(raw.hrp, raw.signed)); err != nil {
+		logger.Error("Invoice signature verification failed")
+		return nil, err
+	}
+	logger.Debug("Invoice signed by payee %x", inv.Payee)
+
+	logger.Debug("Invoice decoding complete")
+	return &inv, nil
+}
+
+// rawField is a tagged field whose data is still in 5-bit words.
+type rawField struct {
+	tag  byte
+	data []byte
+}
+
+// rawInvoiceParts is an invoice split into its human-readable part, tagged
+// fields and signature, with the checksum verified and every field checked
+// to lie within the data part.
+type rawInvoiceParts struct {
+	hrp        []byte
+	network    Network
+	amountMsat uint64
+	timestamp  []byte
+	fields     []rawField
+	signature  []byte
+	// signed is the data part the signature covers: timestamp and fields.
+	signed []byte
+}
+
+// splitInvoice does the parsing DecodeInvoice and ParseInvoice share. It
+// verifies the checksum before looking at the amount, so that a corrupted
+// invoice is always reported as ErrBadChecksum.
+func splitInvoice(invoice []byte, logger *Logger) (*rawInvoiceParts, error) {
+	invoice = bytes.ToLower(invoice)
+	pos := bytes.LastIndexByte(invoice, byte('1'))
+	if pos == -1 || !isBech32.Match(invoice) {
+		logger.Error("Invalid invoice format")
+		return nil, ErrInvalidInvoice
+	}
+	data, err := fromChars(invoice[pos+1:])
+	if err != nil || !verifyChecksum(invoice[:pos], data) {
+		logger.Error("Invoice checksum verification failed")
+		return nil, ErrBadChecksum
+	}
+	if len(data) < timestampWords+signatureWords+checksumWords {
+		logger.Error("Invoice data part too short")
+		return nil, ErrInvoiceTooShort
+	}
+
+	raw := rawInvoiceParts{hrp: invoice[:pos]}
+	raw.network, raw.amountMsat, err = parseHRP(raw.hrp)
+	if err != nil {
+		logger.Error("Failed to parse amount: %v", err)
+		return nil, err
+	}
+
+	data = data[:len(data)-checksumWords]
+	raw.signed = data[:len(data)-signatureWords]
+	raw.signature = data[len(data)-signatureWords:]
+	raw.timestamp = data[:timestampWords]
+
+	fields := raw.signed[timestampWords:]
+	for i := 0; i < len(fields); {
+		if i+3 > len(fields) {
+			logger.Error("Truncated field header at %d", i)
+			return nil, ErrTruncatedField
+		}
+		tag := charSet[fields[i]]
+		length := int(fields[i+1])*32 + int(fields[i+2])
+		if i+3+length > len(fields) {
+			logger.Error("Field %c overruns invoice data", tag)
+			return nil, ErrTruncatedField
+		}
+		logger.Debug("Found field type %c with length %d", tag, length)
+		raw.fields = append(raw.fields, rawField{tag: tag, data: fields[i+3 : i+3+length]})
+		i += 3 + length
+	}
+	return &raw, nil
+}
+
+// decodeField stores the value of a single tagged field on the invoice.
+func (inv *Invoice) decodeField(tag byte, field []byte) error {
+	switch tag {
+	case byte('p'):
+		if len(field) == 52 {
+			inv.PaymentHash = convertBits(field, 5, 8, false)
+		}
+	case byte('s'):
+		if len(field) == 52 {
+			inv.PaymentSecret = convertBits(field, 5, 8, false)
+		}
+	case byte('d'):
+		inv.Description = string(convertBits(field, 5, 8, false))
+	case byte('h'):
+		if len(field) == 52 {
+			inv.DescriptionHash = convertBits(field, 5, 8, false)
+		}
+	case byte('n'):
+		if len(field) == 53 {
+			inv.Payee = convertBits(field, 5, 8, false)
+		}
+	case byte('m'):
+		inv.Metadata = convertBits(field, 5, 8, false)
+	case byte('x'):
+		// Anything longer than time.Duration can hold would wrap around to
+		// an expiry in the past or near future.
+		if len(field) > 12 || wordsToUint(field) > math.MaxInt64/uint64(time.Second) {
+			return fmt.Errorf("%w: expiry too large", ErrInvalidField)
+		}
+		inv.Expiry = time.Duration(wordsToUint(field)) * time.Second
+	case byte('c'):
+		if len(field) > 12 {
+			return fmt.Errorf("%w: min_final_cltv_expiry too large", ErrInvalidField)
+		}
+		inv.MinFinalCLTVExpiry = wordsToUint(field)
+	case byte('f'):
+		if len(field) == 0 {
+			return nil
+		}
+		inv.FallbackAddresses = append(inv.FallbackAddresses, FallbackAddress{
+			Version: field[0],
+			Program: convertBits(field[1:], 5, 8, false),
+		})
+	case byte('r'):
+		hops := convertBits(field, 5, 8, false)
+		if len(hops) == 0 || len(hops)%hopHintLength != 0 {
+			return fmt.Errorf("%w: route hint length", ErrInvalidField)
+		}
+		var route RouteHint
+		for ; len(hops) > 0; hops = hops[hopHintLength:] {
+			route = append(route, HopHint{
+				PubKey:                    hops[:33],
+				ShortChannelID:            binary.BigEndian.Uint64(hops[33:41]),
+				FeeBaseMsat:               binary.BigEndian.Uint32(hops[41:45]),
+				FeeProportionalMillionths: binary.BigEndian.Uint32(hops[45:49]),
+				CLTVExpiryDelta:           binary.BigEndian.Uint16(hops[49:51]),
+			})
+		}
+		inv.RouteHints = append(inv.RouteHints, route)
+	case byte('9'):
+		inv.Features = nil
+		for i := len(field) - 1; i >= 0; i-- {
+			for b := 0; b < 5; b++ {
+				if field[i]&(1<<b) != 0 {
+					inv.Features = append(inv.Features, (len(field)-1-i)*5+b)
+				}
+			}
+		}
+	}
+	return nil
+}
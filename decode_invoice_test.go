@@ -0,0 +1,172 @@
+package client
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/decred/dcrd/dcrec/secp256k1/v4"
+	"github.com/decred/dcrd/dcrec/secp256k1/v4/ecdsa"
+)
+
+func mustHex(s string) []byte {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+// taggedField encodes a tagged field from its tag and 8-bit payload.
+func taggedField(tag byte, payload []byte) []byte {
+	words := convertBits(payload, 8, 5, true)
+	return append([]byte{tag, charSet[len(words)/32], charSet[len(words)%32]}, toChars(words)...)
+}
+
+// taggedWords encodes a tagged field whose payload is already 5-bit words.
+func taggedWords(tag byte, words []byte) []byte {
+	return append([]byte{tag, charSet[len(words)/32], charSet[len(words)%32]}, toChars(words)...)
+}
+
+// testKey is the node key used by the BOLT11 test vectors.
+var testKey = secp256k1.PrivKeyFromBytes(mustHex("e126f68f7eafcc8b74f54d269fe206be715000f94dac067d1c04a8ca3b2db734"))
+
+// rawInvoice assembles an invoice around the given tagged fields and signs
+// it with key.
+func rawInvoice(key *secp256k1.PrivateKey, hrp string, timestamp uint64, fields ...[]byte) string {
+	var data []byte
+	for i := timestampWords - 1; i >= 0; i-- {
+		data = append(data, charSet[timestamp>>(5*i)&31])
+	}
+	for _, f := range fields {
+		data = append(data, f...)
+	}
+	words, _ := fromChars(data)
+	hash := signingHash([]byte(hrp), words)
+	compact := ecdsa.SignCompact(key, hash[:], true)
+	signature := append(compact[1:], compact[0]-compactSigMagicOffset)
+	data = append(data, toChars(convertBits(signature, 8, 5, true))...)
+	words, _ = fromChars(data)
+	data = append(data, toChars(createChecksum([]byte(hrp), words))...)
+	return hrp + "1" + string(data)
+}
+
+func TestDecodeInvoice(t *testing.T) {
+	invoice := "lnbc2500u1pvjluezsp5zyg3zyg3zyg3zyg3zyg3zyg3zyg3zyg3zyg3zyg3zyg3zyg3zygspp5qqqsyqcyq5rqwzqfqqqsyqcyq5rqwzqfqqqsyqcyq5rqwzqfqypqdq5xysxxatsyp3k7enxv4jsxqzpu9qrsgquk0rl77nj30yxdy8j9vdx85fkpmdla2087ne0xh8nhedh8w27kyke0lp53ut353s06fv3qfegext0eh0ymjpf39tuven09sam30g4vgpfna3rh"
+	want := &Invoice{
+		Network:            Mainnet,
+		AmountMsat:         250000000,
+		Timestamp:          time.Unix(1496314658, 0),
+		PaymentHash:        mustHex("0001020304050607080900010203040506070809000102030405060708090102"),
+		PaymentSecret:      bytes.Repeat([]byte{0x11}, 32),
+		Description:        "1 cup coffee",
+		Expiry:             time.Minute,
+		MinFinalCLTVExpiry: DefaultMinFinalCLTVExpiry,
+		Features:           FeatureVector{8, 14},
+		Payee:              mustHex("03e7156ae33b0a208d0744199163177e909e80176e55d97a2f221ede0f934dd9ad"),
+		Signature:          mustHex("e59e3ffbd3945e4334879158d31e89b076dff54f3fa7979ae79df2db9dcaf5896cbfe1a478b8d2307e92c88139464cb7e6ef26e414c4abe33337961ddc5e8ab1"),
+		RecoveryID:         1,
+	}
+	got, err := DecodeInvoice([]byte(invoice))
+	if err != nil {
+		t.Fatalf("DecodeInvoice failed: %v", err)
+	}
+	if !reflect.DeepEqual(want, got) {
+		t.Fatalf("\nwanted: %+v\ngot: %+v\n", want, got)
+	}
+}
+
+func TestDecodeInvoiceFields(t *testing.T) {
+	payee := mustHex("03e7156ae33b0a208d0744199163177e909e80176e55d97a2f221ede0f934dd9ad")
+	hop := append(append([]byte{}, payee...),
+		0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08, // short_channel_id
+		0x00, 0x00, 0x00, 0x01, // fee_base_msat
+		0x00, 0x00, 0x00, 0x14, // fee_proportional_millionths
+		0x00, 0x03, // cltv_expiry_delta
+	)
+	program := mustHex("751e76e8199196d454941c45d1b3a323f1433bd6")
+	invoice := rawInvoice(testKey, "lnbc20m", 1496314658,
+		taggedField('p', bytes.Repeat([]byte{0x01}, 32)),
+		taggedField('h', bytes.Repeat([]byte{0x02}, 32)),
+		taggedField('n', payee),
+		taggedField('m', []byte{0x01, 0xfa, 0xfa}),
+		taggedWords('x', []byte{1, 28}),
+		taggedWords('c', []byte{1, 8}),
+		taggedWords('f', append([]byte{0}, convertBits(program, 8, 5, true)...)),
+		taggedField('r', append(append([]byte{}, hop...), hop...)),
+		taggedWords('9', []byte{1, 0, 0}),
+		taggedField('p', []byte{0xff}), // wrong length, must be skipped
+	)
+
+	got, err := DecodeInvoice([]byte(invoice))
+	if err != nil {
+		t.Fatalf("DecodeInvoice failed: %v", err)
+	}
+	if got.AmountMsat != 2_000_000_000 {
+		t.Errorf("Expected amount 2000000000 msat, got %d", got.AmountMsat)
+	}
+	if !bytes.Equal(got.PaymentHash, bytes.Repeat([]byte{0x01}, 32)) {
+		t.Errorf("Unexpected payment hash %x", got.PaymentHash)
+	}
+	if !bytes.Equal(got.DescriptionHash, bytes.Repeat([]byte{0x02}, 32)) {
+		t.Errorf("Unexpected description hash %x", got.DescriptionHash)
+	}
+	if !bytes.Equal(got.Payee, payee) {
+		t.Errorf("Unexpected payee %x", got.Payee)
+	}
+	if !bytes.Equal(got.Metadata, []byte{0x01, 0xfa, 0xfa}) {
+		t.Errorf("Unexpected metadata %x", got.Metadata)
+	}
+	if got.Expiry != 60*time.Second {
+		t.Errorf("Expected expiry 1m0s, got %s", got.Expiry)
+	}
+	if got.MinFinalCLTVExpiry != 40 {
+		t.Errorf("Expected min_final_cltv_expiry 40, got %d", got.MinFinalCLTVExpiry)
+	}
+	wantFallback := []FallbackAddress{{Version: 0, Program: program}}
+	if !reflect.DeepEqual(got.FallbackAddresses, wantFallback) {
+		t.Errorf("Unexpected fallback addresses %+v", got.FallbackAddresses)
+	}
+	wantHop := HopHint{
+		PubKey:                    payee,
+		ShortChannelID:            0x0102030405060708,
+		FeeBaseMsat:               1,
+		FeeProportionalMillionths: 20,
+		CLTVExpiryDelta:           3,
+	}
+	wantRoutes := []RouteHint{{wantHop, wantHop}}
+	if !reflect.DeepEqual(got.RouteHints, wantRoutes) {
+		t.Errorf("Unexpected route hints %+v", got.RouteHints)
+	}
+	if !reflect.DeepEqual(got.Features, FeatureVector{10}) || !got.Features.IsSet(10) || got.Features.IsSet(8) {
+		t.Errorf("Unexpected features %v", got.Features)
+	}
+}
+
+func TestDecodeInvoiceSignature(t *testing.T) {
+	otherKey := secp256k1.PrivKeyFromBytes(bytes.Repeat([]byte{0x42}, 32))
+	hash := taggedField('p', bytes.Repeat([]byte{0x01}, 32))
+
+	got, err := DecodeInvoice([]byte(rawInvoice(otherKey, "lnbc1m", 1496314658, hash)))
+	if err != nil {
+		t.Fatalf("DecodeInvoice failed: %v", err)
+	}
+	if !bytes.Equal(got.Payee, otherKey.PubKey().SerializeCompressed()) {
+		t.Errorf("Recovered payee %x, expected %x", got.Payee, otherKey.PubKey().SerializeCompressed())
+	}
+
+	// n field naming a different node than the signer
+	forged := rawInvoice(otherKey, "lnbc1m", 1496314658, hash, taggedField('n', testKey.PubKey().SerializeCompressed()))
+	if _, err := DecodeInvoice([]byte(forged)); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("Expected ErrInvalidSignature for mismatched n field, got %v", err)
+	}
+
+	// n field naming the signer
+	named := rawInvoice(otherKey, "lnbc1m", 1496314658, hash, taggedField('n', otherKey.PubKey().SerializeCompressed()))
+	if _, err := DecodeInvoice([]byte(named)); err != nil {
+		t.Errorf("Expected matching n field to verify, got %v", err)
+	}
+}
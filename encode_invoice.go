@@ -0,0 +1,175 @@
+package client
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/decred/dcrd/dcrec/secp256k1/v4"
+	"github.com/decred/dcrd/dcrec/secp256k1/v4/ecdsa"
+)
+
+// maxTimestamp is the largest timestamp that fits in seven 5-bit words.
+const maxTimestamp = 1<<35 - 1
+
+// EncodeInvoice serialises inv as a BOLT11 invoice signed with key.
+//
+// Network defaults to Mainnet and a zero Timestamp to the current time. A
+// DescriptionHash takes precedence over Description. Expiry and
+// MinFinalCLTVExpiry are only written when they differ from their defaults.
+// Payee and Signature are ignored: the payee is the node owning key, and it
+// is recovered from the signature rather than written in an n field.
+func EncodeInvoice(inv *Invoice, key *secp256k1.PrivateKey) (string, error) {
+	logger := DefaultLogger().WithComponent("InvoiceEncoder")
+
+	network := inv.Network
+	if network == "" {
+		network = Mainnet
+	}
+	hrp := "ln" + string(network)
+	if inv.AmountMsat > 0 {
+		hrp += encodeAmount(inv.AmountMsat)
+	}
+
+	timestamp := inv.Timestamp
+	if timestamp.IsZero() {
+		timestamp = time.Now()
+	}
+	if timestamp.Unix() < 0 || timestamp.Unix() > maxTimestamp {
+		return "", errors.New("timestamp out of range")
+	}
+
+	if len(inv.PaymentHash) != 32 {
+		return "", errors.New("payment hash must be 32 bytes")
+	}
+	if inv.PaymentSecret != nil && len(inv.PaymentSecret) != 32 {
+		return "", errors.New("payment secret must be 32 bytes")
+	}
+	if inv.DescriptionHash != nil && len(inv.DescriptionHash) != 32 {
+		return "", errors.New("description hash must be 32 bytes")
+	}
+	if inv.Expiry%time.Second != 0 || inv.Expiry < 0 {
+		return "", errors.New("expiry must be a positive whole number of seconds")
+	}
+
+	data := uintToWords(uint64(timestamp.Unix()), timestampWords)
+	if inv.PaymentSecret != nil {
+		data = appendField(data, 's', convertBits(inv.PaymentSecret, 8, 5, true))
+	}
+	data = appendField(data, 'p', convertBits(inv.PaymentHash, 8, 5, true))
+	if inv.DescriptionHash != nil {
+		data = appendField(data, 'h', convertBits(inv.DescriptionHash, 8, 5, true))
+	} else {
+		data = appendField(data, 'd', convertBits([]byte(inv.Description), 8, 5, true))
+	}
+	if inv.Metadata != nil {
+		data = appendField(data, 'm', convertBits(inv.Metadata, 8, 5, true))
+	}
+	if inv.Expiry != 0 && inv.Expiry != DefaultExpiry {
+		data = appendField(data, 'x', uintToWords(uint64(inv.Expiry/time.Second), 0))
+	}
+	if inv.MinFinalCLTVExpiry != 0 && inv.MinFinalCLTVExpiry != DefaultMinFinalCLTVExpiry {
+		data = appendField(data, 'c', uintToWords(inv.MinFinalCLTVExpiry, 0))
+	}
+	for _, f := range inv.FallbackAddresses {
+		if f.Version > 31 {
+			return "", errors.New("invalid fallback address version")
+		}
+		data = appendField(data, 'f', append([]byte{f.Version}, convertBits(f.Program, 8, 5, true)...))
+	}
+	for _, route := range inv.RouteHints {
+		hops := make([]byte, 0, len(route)*hopHintLength)
+		for _, hop := range route {
+			if len(hop.PubKey) != 33 {
+				return "", errors.New("route hint public key must be 33 bytes")
+			}
+			hops = append(hops, hop.PubKey...)
+			hops = binary.BigEndian.AppendUint64(hops, hop.ShortChannelID)
+			hops = binary.BigEndian.AppendUint32(hops, hop.FeeBaseMsat)
+			hops = binary.BigEndian.AppendUint32(hops, hop.FeeProportionalMillionths)
+			hops = binary.BigEndian.AppendUint16(hops, hop.CLTVExpiryDelta)
+		}
+		data = appendField(data, 'r', convertBits(hops, 8, 5, true))
+	}
+	if len(inv.Features) > 0 {
+		words, err := inv.Features.words()
+		if err != nil {
+			return "", err
+		}
+		data = appendField(data, '9', words)
+	}
+	if data == nil {
+		return "", errors.New("tagged field too long")
+	}
+
+	hash := signingHash([]byte(hrp), data)
+	compact := ecdsa.SignCompact(key, hash[:], true)
+	signature := append(compact[1:], compact[0]-compactSigMagicOffset)
+	data = append(data, convertBits(signature, 8, 5, true)...)
+	data = append(data, createChecksum([]byte(hrp), data)...)
+
+	invoice := make([]byte, 0, len(hrp)+1+len(data))
+	invoice = append(invoice, hrp...)
+	invoice = append(invoice, '1')
+	for _, w := range data {
+		invoice = append(invoice, charSet[w])
+	}
+	logger.Debug("Encoded invoice: %s", string(invoice[:min(len(invoice), 40)])+"...")
+	return string(invoice), nil
+}
+
+// encodeAmount returns the shortest amount and multiplier for amountMsat.
+func encodeAmount(amountMsat uint64) string {
+	switch {
+	case amountMsat%100_000_000 == 0:
+		return strconv.FormatUint(amountMsat/100_000_000, 10) + "m"
+	case amountMsat%100_000 == 0:
+		return strconv.FormatUint(amountMsat/100_000, 10) + "u"
+	case amountMsat%100 == 0:
+		return strconv.FormatUint(amountMsat/100, 10) + "n"
+	default:
+		return strconv.FormatUint(amountMsat*10, 10) + "p"
+	}
+}
+
+// appendField appends a tagged field holding words to data. Once a field
+// exceeds the 1023 words its length can express, data becomes nil and
+// stays nil through later calls.
+func appendField(data []byte, tag byte, words []byte) []byte {
+	if data == nil || len(words) > 1023 {
+		return nil
+	}
+	data = append(data, byte(bytes.IndexByte(charSet, tag)), byte(len(words)/32), byte(len(words)%32))
+	return append(data, words...)
+}
+
+// uintToWords encodes n as big-endian 5-bit words, using at least minWords
+// words and otherwise as few as possible.
+func uintToWords(n uint64, minWords int) []byte {
+	var words []byte
+	for n > 0 || len(words) < minWords {
+		words = append([]byte{byte(n & 31)}, words...)
+		n >>= 5
+	}
+	return words
+}
+
+// words encodes the feature vector as 5-bit words, lowest bits last.
+func (f FeatureVector) words() ([]byte, error) {
+	highest := 0
+	for _, bit := range f {
+		if bit < 0 {
+			return nil, errors.New("invalid feature bit")
+		}
+		if bit > highest {
+			highest = bit
+		}
+	}
+	words := make([]byte, highest/5+1)
+	for _, bit := range f {
+		words[len(words)-1-bit/5] |= 1 << (bit % 5)
+	}
+	return words, nil
+}
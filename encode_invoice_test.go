@@ -0,0 +1,102 @@
+package client
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/decred/dcrd/dcrec/secp256k1/v4"
+)
+
+// mustEncode signs inv with key, failing the test on error.
+func mustEncode(t *testing.T, inv *Invoice, key *secp256k1.PrivateKey) string {
+	t.Helper()
+	invoice, err := EncodeInvoice(inv, key)
+	if err != nil {
+		t.Fatalf("EncodeInvoice failed: %v", err)
+	}
+	return invoice
+}
+
+func TestEncodeInvoiceVectors(t *testing.T) {
+	// BOLT11 test vectors signed with testKey; signatures are deterministic
+	// so re-encoding the decoded invoice must reproduce them exactly.
+	for _, want := range []string{
+		"lnbc1pvjluezsp5zyg3zyg3zyg3zyg3zyg3zyg3zyg3zyg3zyg3zyg3zyg3zyg3zygspp5qqqsyqcyq5rqwzqfqqqsyqcyq5rqwzqfqqqsyqcyq5rqwzqfqypqdpl2pkx2ctnv5sxxmmwwd5kgetjypeh2ursdae8g6twvus8g6rfwvs8qun0dfjkxaq9qrsgq357wnc5r2ueh7ck6q93dj32dlqnls087fxdwk8qakdyafkq3yap9us6v52vjjsrvywa6rt52cm9r9zqt8r2t7mlcwspyetp5h2tztugp9lfyql",
+		"lnbc2500u1pvjluezsp5zyg3zyg3zyg3zyg3zyg3zyg3zyg3zyg3zyg3zyg3zyg3zyg3zygspp5qqqsyqcyq5rqwzqfqqqsyqcyq5rqwzqfqqqsyqcyq5rqwzqfqypqdq5xysxxatsyp3k7enxv4jsxqzpu9qrsgquk0rl77nj30yxdy8j9vdx85fkpmdla2087ne0xh8nhedh8w27kyke0lp53ut353s06fv3qfegext0eh0ymjpf39tuven09sam30g4vgpfna3rh",
+	} {
+		inv, err := DecodeInvoice([]byte(want))
+		if err != nil {
+			t.Fatalf("DecodeInvoice failed: %v", err)
+		}
+		if got := mustEncode(t, inv, testKey); got != want {
+			t.Errorf("\nwanted: %s\ngot:    %s", want, got)
+		}
+	}
+}
+
+func TestEncodeInvoiceRoundTrip(t *testing.T) {
+	key := secp256k1.PrivKeyFromBytes(bytes.Repeat([]byte{0x42}, 32))
+	want := &Invoice{
+		Network:            Regtest,
+		AmountMsat:         1234567,
+		Timestamp:          time.Unix(1700000000, 0),
+		PaymentHash:        bytes.Repeat([]byte{0x01}, 32),
+		PaymentSecret:      bytes.Repeat([]byte{0x02}, 32),
+		DescriptionHash:    bytes.Repeat([]byte{0x03}, 32),
+		Expiry:             10 * time.Minute,
+		MinFinalCLTVExpiry: 144,
+		FallbackAddresses:  []FallbackAddress{{Version: 17, Program: bytes.Repeat([]byte{0x04}, 20)}},
+		RouteHints: []RouteHint{{
+			{PubKey: testKey.PubKey().SerializeCompressed(), ShortChannelID: 0x0102030405060708, FeeBaseMsat: 1000, FeeProportionalMillionths: 100, CLTVExpiryDelta: 40},
+			{PubKey: key.PubKey().SerializeCompressed(), ShortChannelID: 42, FeeBaseMsat: 0, FeeProportionalMillionths: 1, CLTVExpiryDelta: 144},
+		}},
+		Features: FeatureVector{9, 14, 17, 48},
+		Metadata: []byte{0xca, 0xfe},
+		Payee:    key.PubKey().SerializeCompressed(),
+	}
+
+	invoice := mustEncode(t, want, key)
+	got, err := DecodeInvoice([]byte(invoice))
+	if err != nil {
+		t.Fatalf("DecodeInvoice failed: %v", err)
+	}
+	got.Signature, got.RecoveryID = nil, 0
+	if !reflect.DeepEqual(want, got) {
+		t.Fatalf("\nwanted: %+v\ngot:    %+v", want, got)
+	}
+}
+
+func TestEncodeInvoiceAmounts(t *testing.T) {
+	for amount, want := range map[uint64]string{
+		200_000_000: "lnbc2m1",
+		250_000:     "lnbc2500n1",
+		1_500_000:   "lnbc15u1",
+		1:           "lnbc10p1",
+	} {
+		invoice := mustEncode(t, &Invoice{AmountMsat: amount, PaymentHash: make([]byte, 32)}, testKey)
+		if !bytes.HasPrefix([]byte(invoice), []byte(want)) {
+			t.Errorf("Amount %d msat: expected prefix %s, got %s", amount, want, invoice[:len(want)])
+		}
+		got, err := DecodeInvoice([]byte(invoice))
+		if err != nil || got.AmountMsat != amount {
+			t.Errorf("Amount %d msat did not round trip: %v, %v", amount, got, err)
+		}
+	}
+}
+
+func TestEncodeInvoiceErrors(t *testing.T) {
+	for name, inv := range map[string]*Invoice{
+		"short payment hash":  {PaymentHash: make([]byte, 31)},
+		"long secret":         {PaymentHash: make([]byte, 32), PaymentSecret: make([]byte, 33)},
+		"fractional expiry":   {PaymentHash: make([]byte, 32), Expiry: 1500 * time.Millisecond},
+		"negative feature":    {PaymentHash: make([]byte, 32), Features: FeatureVector{-1}},
+		"oversized metadata":  {PaymentHash: make([]byte, 32), Metadata: make([]byte, 700)},
+		"short route pub key": {PaymentHash: make([]byte, 32), RouteHints: []RouteHint{{{PubKey: make([]byte, 32)}}}},
+	} {
+		if _, err := EncodeInvoice(inv, testKey); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
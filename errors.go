@@ -0,0 +1,139 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Classes of relay errors, matched by a *RelayError whose reason or status
+// is recognised. The client also returns InvoiceExpired itself for invoices
+// with too little lifetime left, see LNProxy.MinRemaining.
+var (
+	AmountTooSmall             = errors.New("amount too small for relay")
+	InvoiceExpired             = errors.New("invoice expired or expiring too soon")
+	NoRoute                    = errors.New("relay found no route to destination")
+	RelayOverCapacity          = errors.New("relay over capacity")
+	RateLimited                = errors.New("rate limited by relay")
+	DescriptionHashUnsupported = errors.New("relay does not support description hash")
+)
+
+// reasonClasses maps relay reasons, matched case-insensitively as
+// substrings, to error classes. Earlier entries take precedence.
+var reasonClasses = []struct {
+	class    error
+	patterns []string
+}{
+	{RateLimited, []string{"rate limit", "too many requests", "slow down"}},
+	// The field name alone also appears in reasons such as "invalid
+	// description_hash", which are about the value, not its support.
+	{DescriptionHashUnsupported, []string{
+		"description hash not supported", "description_hash not supported",
+		"description hash is not supported", "description_hash is not supported",
+		"description hash unsupported", "description_hash unsupported",
+		"unsupported description hash", "unsupported description_hash",
+		"does not support description hash", "does not support description_hash",
+	}},
+	{InvoiceExpired, []string{"expired", "expires too soon", "expiry too short", "too soon"}},
+	{AmountTooSmall, []string{"too small", "too low", "below minimum", "minimum amount"}},
+	{NoRoute, []string{"no route", "unable to find a path", "could not find route", "route not found"}},
+	{RelayOverCapacity, []string{"capacity", "liquidity", "too many pending", "busy"}},
+}
+
+// RelayError is returned when a relay answers a request with an error. It
+// matches LNProxyError with errors.Is, as well as the class of error its
+// reason or status falls in, such as InvoiceExpired or RateLimited.
+type RelayError struct {
+	URL string
+	// HTTPStatus is the status code of the response and Status the status
+	// field of its body, usually "ERROR".
+	HTTPStatus int
+	Status     string
+	Reason     string
+}
+
+func (e *RelayError) Error() string {
+	if e.Reason == "" {
+		return fmt.Sprintf("lnproxy error: HTTP %d", e.HTTPStatus)
+	}
+	return fmt.Sprintf("lnproxy error: %s (HTTP %d)", e.Reason, e.HTTPStatus)
+}
+
+func (e *RelayError) Unwrap() []error {
+	if class := e.Class(); class != nil {
+		return []error{LNProxyError, class}
+	}
+	return []error{LNProxyError}
+}
+
+// Class returns the class of the error, or nil when neither its reason nor
+// its status is recognised
+func (e *RelayError) Class() error {
+	reason := strings.ToLower(e.Reason)
+	for _, c := range reasonClasses {
+		for _, pattern := range c.patterns {
+			if strings.Contains(reason, pattern) {
+				return c.class
+			}
+		}
+	}
+	switch e.HTTPStatus {
+	case http.StatusTooManyRequests:
+		return RateLimited
+	case http.StatusServiceUnavailable:
+		return RelayOverCapacity
+	}
+	return nil
+}
+
+// TimeoutError is returned when a relay does not answer before the request
+// deadline. It is distinct from LNProxyError, which reports a relay that
+// answered with an error.
+type TimeoutError struct {
+	URL string
+	// Limit is the client's per-request Timeout, or zero when the
+	// deadline came from the caller's context.
+	Limit time.Duration
+	Err   error
+}
+
+func (e *TimeoutError) Error() string {
+	if e.Limit > 0 {
+		return fmt.Sprintf("lnproxy relay %s timed out after %s: %v", e.URL, e.Limit, e.Err)
+	}
+	return fmt.Sprintf("lnproxy relay %s timed out: %v", e.URL, e.Err)
+}
+
+func (e *TimeoutError) Unwrap() error {
+	return e.Err
+}
+
+// Timeout always reports true, matching the Timeout method of net.Error.
+func (e *TimeoutError) Timeout() bool {
+	return true
+}
+
+// timeoutError wraps err in a *TimeoutError when it stems from a deadline,
+// and returns it unchanged otherwise. parent is the caller's context, before
+// the client's RequestTimeout was applied to it. Limit is the shorter of
+// RequestTimeout and Client.Timeout, or zero when the caller's deadline
+// passed.
+func (x *LNProxy) timeoutError(parent context.Context, err error) error {
+	var netErr net.Error
+	if !errors.Is(err, context.DeadlineExceeded) && !(errors.As(err, &netErr) && netErr.Timeout()) {
+		return err
+	}
+	x.logger.Error("Request to %s timed out", x.URL.String())
+	timeoutErr := &TimeoutError{URL: x.URL.String(), Limit: x.RequestTimeout, Err: err}
+	switch {
+	case parent.Err() != nil:
+		timeoutErr.Limit = 0
+	case x.Client.Timeout > 0 && (x.RequestTimeout == 0 || x.Client.Timeout < x.RequestTimeout):
+		timeoutErr.Limit = x.Client.Timeout
+	}
+	return timeoutErr
+}
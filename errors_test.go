@@ -0,0 +1,70 @@
+package client
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRelayErrorClass(t *testing.T) {
+	tests := []struct {
+		status int
+		reason string
+		class  error
+	}{
+		{http.StatusBadRequest, "Invoice expired", InvoiceExpired},
+		{http.StatusBadRequest, "invoice expires too soon", InvoiceExpired},
+		{http.StatusBadRequest, "payment amount too small", AmountTooSmall},
+		{http.StatusBadRequest, "description hash not supported", DescriptionHashUnsupported},
+		{http.StatusBadRequest, "description_hash is not supported by this relay", DescriptionHashUnsupported},
+		{http.StatusBadRequest, "relay does not support description hash", DescriptionHashUnsupported},
+		{http.StatusBadRequest, "invalid description_hash", nil},
+		{http.StatusInternalServerError, "unable to find a path to destination", NoRoute},
+		{http.StatusInternalServerError, "insufficient liquidity", RelayOverCapacity},
+		{http.StatusServiceUnavailable, "", RelayOverCapacity},
+		{http.StatusTooManyRequests, "slow down", RateLimited},
+		{http.StatusTooManyRequests, "", RateLimited},
+		{http.StatusBadRequest, "Invalid invoice", nil},
+	}
+	for _, tt := range tests {
+		err := &RelayError{HTTPStatus: tt.status, Reason: tt.reason}
+		if class := err.Class(); class != tt.class {
+			t.Errorf("Class of %d %q = %v, expected %v", tt.status, tt.reason, class, tt.class)
+		}
+		if !errors.Is(err, LNProxyError) {
+			t.Errorf("Expected %d %q to match LNProxyError", tt.status, tt.reason)
+		}
+		if tt.class != nil && !errors.Is(err, tt.class) {
+			t.Errorf("Expected %d %q to match %v", tt.status, tt.reason, tt.class)
+		}
+	}
+}
+
+func TestRequestProxyRelayError(t *testing.T) {
+	relay := newFailingRelay(t, http.StatusBadRequest, "Invoice expired")
+	_, err := relay.RequestProxy("test-invoice", 1500)
+	var relayErr *RelayError
+	if !errors.As(err, &relayErr) {
+		t.Fatalf("Expected RelayError, got %v", err)
+	}
+	want := RelayError{URL: relay.URL.String(), HTTPStatus: http.StatusBadRequest, Status: "ERROR", Reason: "Invoice expired"}
+	if *relayErr != want {
+		t.Errorf("Expected %+v, got %+v", want, *relayErr)
+	}
+	if !errors.Is(err, InvoiceExpired) || !strings.Contains(err.Error(), "Invoice expired") {
+		t.Errorf("Expected InvoiceExpired error naming the reason, got %v", err)
+	}
+
+	// A proxy in front of the relay answers with an HTML error page
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+		w.Write([]byte("<html>502 Bad Gateway</html>"))
+	}))
+	defer server.Close()
+	_, err = newTestClient(server.URL).RequestProxy("test-invoice", 1500)
+	if !errors.As(err, &relayErr) || relayErr.HTTPStatus != http.StatusBadGateway || !strings.Contains(err.Error(), "502 Bad Gateway") {
+		t.Errorf("Expected RelayError for the malformed response, got %v", err)
+	}
+}
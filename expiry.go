@@ -0,0 +1,44 @@
+package client
+
+import (
+	"fmt"
+	"time"
+)
+
+// DefaultMinRemaining is the lifetime an invoice must have left for a client
+// created by NewLNProxy to wrap it. The relay shortens the proxy invoice's
+// expiry by its own margin, and the payer still needs time to pay.
+const DefaultMinRemaining = 30 * time.Second
+
+// now returns the current time by the client's clock
+func (x *LNProxy) now() time.Time {
+	if x.Now != nil {
+		return x.Now()
+	}
+	return time.Now()
+}
+
+// checkExpiry refuses invoices that have expired or expire within the
+// client's MinRemaining. inv is nil when the invoice could not be decoded.
+func (x *LNProxy) checkExpiry(inv *Invoice) error {
+	if inv == nil {
+		return nil
+	}
+	expiresAt := inv.Timestamp.Add(inv.Expiry)
+	remaining := expiresAt.Sub(x.now())
+	switch {
+	case remaining <= 0:
+		x.logger.Error("Invoice expired at %s", expiresAt.UTC().Format(time.RFC3339))
+		return fmt.Errorf("%w: expired at %s", InvoiceExpired, expiresAt.UTC().Format(time.RFC3339))
+	case remaining < x.MinRemaining:
+		x.logger.Error("Invoice expires in %s, less than the %s required", remaining, x.MinRemaining)
+		return fmt.Errorf("%w: expires in %s, %s required", InvoiceExpired, remaining, x.MinRemaining)
+	}
+	return nil
+}
+
+// WithClock sets the clock invoice expiry is judged by
+func (x *LNProxy) WithClock(now func() time.Time) *LNProxy {
+	x.Now = now
+	return x
+}
@@ -0,0 +1,47 @@
+package client
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRequestProxyExpiry(t *testing.T) {
+	relay, budgets := newAmountlessRelay(t)
+	invoice := mustEncode(t, testInvoice(), testKey)
+	issued := testNow()
+
+	tests := []struct {
+		name         string
+		now          time.Time
+		minRemaining time.Duration
+		refused      bool
+	}{
+		{"fresh", issued, DefaultMinRemaining, false},
+		{"enough left", issued.Add(30 * time.Second), DefaultMinRemaining, false},
+		{"expires too soon", issued.Add(45 * time.Second), DefaultMinRemaining, true},
+		{"expired", issued.Add(time.Hour), 0, true},
+		{"expiry instant", issued.Add(time.Minute), 0, true},
+		{"longer lifetime required", issued, 2 * time.Minute, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			*budgets = nil
+			relay.MinRemaining = tt.minRemaining
+			relay.WithClock(func() time.Time { return tt.now })
+			_, err := relay.RequestProxy(invoice, 1500)
+			if !tt.refused {
+				if err != nil {
+					t.Fatalf("RequestProxy failed: %v", err)
+				}
+				return
+			}
+			if !errors.Is(err, InvoiceExpired) {
+				t.Errorf("Expected InvoiceExpired, got %v", err)
+			}
+			if len(*budgets) != 0 {
+				t.Errorf("Expected no request to reach the relay, got %d", len(*budgets))
+			}
+		})
+	}
+}
@@ -0,0 +1,71 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+)
+
+var UnboundedRelayBudget = errors.New("relay-chosen routing budget has no ceiling")
+
+// hasFeePolicy reports whether BaseMsat or Ppm is configured. A client with
+// neither set places no limit on relay fees.
+func (x *LNProxy) hasFeePolicy() bool {
+	return x.BaseMsat != 0 || x.Ppm != 0
+}
+
+// RoutingBudget returns the routing budget the client's fee policy allows
+// for an invoice of amountMsat: BaseMsat plus Ppm millionths of the amount.
+func (x *LNProxy) RoutingBudget(amountMsat uint64) uint64 {
+	return x.BaseMsat + amountMsat/1_000_000*x.Ppm + amountMsat%1_000_000*x.Ppm/1_000_000
+}
+
+// PreviewFee decodes invoice and returns the routing budget the client
+// would request for it. Amountless invoices are budgeted for ZeroAmountMsat
+// when the client allows them.
+func (x *LNProxy) PreviewFee(invoice string) (uint64, error) {
+	inv, err := DecodeInvoice([]byte(invoice))
+	if err != nil {
+		return 0, fmt.Errorf("invalid original invoice: %w", err)
+	}
+	return x.RoutingBudget(x.budgetAmount(inv)), nil
+}
+
+// BudgetCeiling returns the largest routing budget the client accepts from a
+// relay choosing its own budget for an invoice of amountMsat. MaxBudgetMsat
+// and MaxBudgetPpm each cap the budget when set; with neither set the fee
+// policy applies. The second result is false when there is no limit at all,
+// in which case the client refuses to let the relay choose.
+func (x *LNProxy) BudgetCeiling(amountMsat uint64) (uint64, bool) {
+	var ceiling uint64
+	limited := false
+	if x.MaxBudgetMsat != 0 {
+		ceiling, limited = x.MaxBudgetMsat, true
+	}
+	if x.MaxBudgetPpm != 0 {
+		ppmLimit := amountMsat/1_000_000*x.MaxBudgetPpm + amountMsat%1_000_000*x.MaxBudgetPpm/1_000_000
+		if !limited || ppmLimit < ceiling {
+			ceiling, limited = ppmLimit, true
+		}
+	}
+	if !limited && x.hasFeePolicy() {
+		return x.RoutingBudget(amountMsat), true
+	}
+	return ceiling, limited
+}
+
+// ImpliedRoutingBudget returns the routing budget a relay added to the
+// original invoice, read back from the proxy invoice amount.
+func ImpliedRoutingBudget(invoice, proxy_invoice string) (uint64, error) {
+	original, err := DecodeInvoice([]byte(invoice))
+	if err != nil {
+		return 0, fmt.Errorf("invalid original invoice: %w", err)
+	}
+	proxy, err := DecodeInvoice([]byte(proxy_invoice))
+	if err != nil {
+		return 0, fmt.Errorf("%w: %w", InvalidProxyInvoice, err)
+	}
+	if proxy.AmountMsat < original.AmountMsat {
+		return 0, errors.New("proxy amount below original amount")
+	}
+	return proxy.AmountMsat - original.AmountMsat, nil
+}
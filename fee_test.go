@@ -0,0 +1,176 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestRoutingBudget(t *testing.T) {
+	x := NewLNProxy(url.URL{}, 1000, 500)
+	for amount, want := range map[uint64]uint64{
+		0:                  1000,
+		1_000_000:          1500,
+		250_000_000:        126_000,
+		1_999_999:          1999,
+		10_000_000_000_000: 5_000_001_000,
+	} {
+		if got := x.RoutingBudget(amount); got != want {
+			t.Errorf("RoutingBudget(%d) = %d, want %d", amount, got, want)
+		}
+	}
+
+	fee, err := x.PreviewFee(mustEncode(t, testInvoice(), testKey))
+	if err != nil || fee != 126_000 {
+		t.Errorf("PreviewFee = %d, %v; want 126000", fee, err)
+	}
+	if _, err := x.PreviewFee("not-an-invoice"); err == nil {
+		t.Error("Expected PreviewFee to fail on an invalid invoice")
+	}
+}
+
+func TestRequestProxyFeePolicy(t *testing.T) {
+	original := testInvoice()
+	invoice := mustEncode(t, original, testKey)
+	var proxyInvoice string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			RoutingMsat uint64 `json:"routing_msat,string"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		proxyInvoice = mustEncode(t, testProxyInvoice(original, req.RoutingMsat), relayKey)
+		json.NewEncoder(w).Encode(struct {
+			ProxyInvoice string `json:"proxy_invoice"`
+		}{proxyInvoice})
+	}))
+	defer server.Close()
+	serverURL, _ := url.Parse(server.URL)
+	x := NewLNProxy(*serverURL, 1000, 500).WithLogger(NewLogger(LevelError, io.Discard)).WithClock(testNow)
+
+	// No routing budget given: the client's policy decides it
+	got, err := x.RequestProxy(invoice, 0)
+	if err != nil {
+		t.Fatalf("RequestProxy failed: %v", err)
+	}
+	proxy, _ := DecodeInvoice([]byte(got))
+	if proxy.AmountMsat != original.AmountMsat+126_000 {
+		t.Errorf("Expected relay to be asked for 126000 msat, proxy amount is %d", proxy.AmountMsat)
+	}
+	if ok, err := x.ValidateProxyInvoice(invoice, got, 0); !ok || err != nil {
+		t.Errorf("Expected proxy invoice within policy to validate, got %v", err)
+	}
+
+	// An explicit budget above the policy is requested but not accepted
+	got, err = x.RequestProxy(invoice, 200_000)
+	if err != nil {
+		t.Fatalf("RequestProxy failed: %v", err)
+	}
+	if ok, err := x.ValidateProxyInvoice(invoice, got, 200_000); ok || !errors.Is(err, FeePolicyExceeded) {
+		t.Errorf("Expected FeePolicyExceeded, got %v", err)
+	}
+	if ok, err := ValidateProxyInvoice(invoice, got, 200_000); !ok || err != nil {
+		t.Errorf("Package-level validation has no fee policy, got %v", err)
+	}
+	report := x.AuditProxyInvoice(invoice, got, 200_000)
+	if c, _ := report.Check(CheckFeePolicy); c.Expected != "<= 126000 msat" || c.Actual != "200000 msat" {
+		t.Errorf("Unexpected fee policy check: %+v", c)
+	}
+
+	// A relay skimming more than the policy when the client relied on it
+	got = mustEncode(t, testProxyInvoice(original, 130_000), relayKey)
+	if ok, err := x.ValidateProxyInvoice(invoice, got, 0); ok || !errors.Is(err, CustomRoutingBudgetMismatch) || !errors.Is(x.AuditProxyInvoice(invoice, got, 0).Err(), FeePolicyExceeded) {
+		t.Errorf("Expected budget mismatch and fee policy violation, got %v", err)
+	}
+}
+
+func TestBudgetCeiling(t *testing.T) {
+	tests := []struct {
+		name        string
+		x           LNProxy
+		amount      uint64
+		want        uint64
+		wantLimited bool
+	}{
+		{"none", LNProxy{}, 1_000_000, 0, false},
+		{"fee policy", LNProxy{BaseMsat: 1000, Ppm: 500}, 1_000_000, 1500, true},
+		{"absolute", LNProxy{BaseMsat: 1000, MaxBudgetMsat: 5000}, 1_000_000, 5000, true},
+		{"ppm", LNProxy{MaxBudgetPpm: 10_000}, 1_000_000, 10_000, true},
+		{"both, ppm lower", LNProxy{MaxBudgetMsat: 50_000, MaxBudgetPpm: 10_000}, 1_000_000, 10_000, true},
+		{"both, absolute lower", LNProxy{MaxBudgetMsat: 5_000, MaxBudgetPpm: 10_000}, 1_000_000, 5_000, true},
+	}
+	for _, tt := range tests {
+		got, limited := tt.x.BudgetCeiling(tt.amount)
+		if got != tt.want || limited != tt.wantLimited {
+			t.Errorf("%s: BudgetCeiling = %d, %v; want %d, %v", tt.name, got, limited, tt.want, tt.wantLimited)
+		}
+	}
+}
+
+func TestRelayChosenBudget(t *testing.T) {
+	original := testInvoice()
+	invoice := mustEncode(t, original, testKey)
+	relayBudget := uint64(80_000)
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		var req map[string]string
+		json.NewDecoder(r.Body).Decode(&req)
+		if _, ok := req["routing_msat"]; ok {
+			t.Errorf("Expected routing_msat to be omitted, got %q", req["routing_msat"])
+		}
+		json.NewEncoder(w).Encode(struct {
+			ProxyInvoice string `json:"proxy_invoice"`
+		}{mustEncode(t, testProxyInvoice(original, relayBudget), relayKey)})
+	}))
+	defer server.Close()
+	serverURL, _ := url.Parse(server.URL)
+	x := NewLNProxy(*serverURL, 1000, 500).WithLogger(NewLogger(LevelError, io.Discard)).WithClock(testNow)
+	x.RelayChosenBudget = true
+	x.MaxBudgetMsat = 100_000
+
+	got, err := x.RequestProxy(invoice, 0)
+	if err != nil {
+		t.Fatalf("RequestProxy failed: %v", err)
+	}
+	if budget, err := ImpliedRoutingBudget(invoice, got); err != nil || budget != relayBudget {
+		t.Errorf("ImpliedRoutingBudget = %d, %v; want %d", budget, err, relayBudget)
+	}
+	if ok, err := x.ValidateProxyInvoice(invoice, got, 0); !ok || err != nil {
+		t.Errorf("Expected relay-chosen budget under the ceiling to validate, got %v", err)
+	}
+
+	relayBudget = 150_000
+	got, err = x.RequestProxy(invoice, 0)
+	if err != nil {
+		t.Fatalf("RequestProxy failed: %v", err)
+	}
+	if ok, err := x.ValidateProxyInvoice(invoice, got, 0); ok || !errors.Is(err, FeePolicyExceeded) {
+		t.Errorf("Expected FeePolicyExceeded above the ceiling, got %v", err)
+	}
+
+	// A proxy amount below the original is never a valid budget
+	below := testProxyInvoice(original, 0)
+	below.AmountMsat -= 1000
+	cheap := mustEncode(t, below, relayKey)
+	if ok, err := x.ValidateProxyInvoice(invoice, cheap, 0); ok || !errors.Is(err, CustomRoutingBudgetMismatch) {
+		t.Errorf("Expected CustomRoutingBudgetMismatch below the original amount, got %v", err)
+	}
+
+	// Without any ceiling the relay could take whatever it likes
+	unbounded := NewLNProxy(*serverURL, 0, 0).WithLogger(NewLogger(LevelError, io.Discard)).WithClock(testNow)
+	unbounded.RelayChosenBudget = true
+	requests = 0
+	if _, err := unbounded.RequestProxy(invoice, 0); !errors.Is(err, UnboundedRelayBudget) {
+		t.Errorf("Expected UnboundedRelayBudget, got %v", err)
+	}
+	if requests != 0 {
+		t.Errorf("Expected no request to reach the relay, got %d", requests)
+	}
+	if ok, err := unbounded.ValidateProxyInvoice(invoice, got, 0); ok || !errors.Is(err, UnboundedRelayBudget) {
+		t.Errorf("Expected UnboundedRelayBudget from the validator, got %v", err)
+	}
+}
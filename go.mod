@@ -1,3 +1,5 @@
 module github.com/lnproxy/lnproxy-client
 
 go 1.20
+
+require github.com/decred/dcrd/dcrec/secp256k1/v4 v4.4.1
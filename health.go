@@ -0,0 +1,287 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+var RelayCircuitOpen = errors.New("relay circuit open")
+
+// CircuitState is the state of a relay's circuit breaker
+type CircuitState int
+
+const (
+	// CircuitClosed lets every request through.
+	CircuitClosed CircuitState = iota
+	// CircuitOpen rejects requests until the cooldown has passed.
+	CircuitOpen
+	// CircuitHalfOpen lets a single probe through to decide whether the
+	// relay has recovered.
+	CircuitHalfOpen
+)
+
+func (s CircuitState) String() string {
+	switch s {
+	case CircuitClosed:
+		return "closed"
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half-open"
+	default:
+		return fmt.Sprintf("CircuitState(%d)", int(s))
+	}
+}
+
+// HealthPolicy configures relay health tracking and the circuit breaker
+type HealthPolicy struct {
+	// FailureThreshold is the number of consecutive failures that opens the
+	// circuit. Zero disables the circuit breaker.
+	FailureThreshold int
+	// Cooldown is how long an open circuit rejects requests before a probe
+	// is let through.
+	Cooldown time.Duration
+	// Window is the number of recent requests the error rate and latency
+	// percentiles are computed over.
+	Window int
+}
+
+// DefaultHealthPolicy is the health policy of clients created by NewLNProxy
+var DefaultHealthPolicy = HealthPolicy{
+	FailureThreshold: 5,
+	Cooldown:         30 * time.Second,
+	Window:           100,
+}
+
+// RelayHealth is a snapshot of a relay's health. Only requests that reached
+// the relay count: requests the caller cancelled and invoices the client
+// refused to send are left out.
+type RelayHealth struct {
+	URL                 string
+	State               CircuitState
+	ConsecutiveFailures int
+	// Requests is the number of requests in the rolling window, and
+	// ErrorRate the share of them that failed.
+	Requests  int
+	ErrorRate float64
+	// ValidationFailures counts the proxy invoices from this relay that
+	// failed validation since the client was created.
+	ValidationFailures int
+	LatencyP50         time.Duration
+	LatencyP90         time.Duration
+	LatencyP99         time.Duration
+	LastError          error
+	// RetryAt is when an open circuit lets the next probe through.
+	RetryAt time.Time
+}
+
+// healthSample is one request in the rolling window
+type healthSample struct {
+	latency time.Duration
+	failed  bool
+}
+
+// sampleHandle identifies a request recorded by observe, so that its
+// outcome can be revised once the proxy invoice has been validated
+type sampleHandle struct {
+	// seq numbers the request among all those observed; recorded is false
+	// when nothing was observed.
+	seq      uint64
+	recorded bool
+	// probe is set when the request was the probe of a half-open circuit.
+	probe bool
+}
+
+// relayHealth tracks the health of one relay. All methods are safe for
+// concurrent use and do nothing on a nil receiver.
+type relayHealth struct {
+	mu      sync.Mutex
+	policy  HealthPolicy
+	now     func() time.Time
+	samples []healthSample
+	// observed counts every request observed; the sample of request n is
+	// at n % Window while it is still in the window.
+	observed uint64
+
+	state               CircuitState
+	probing             bool
+	consecutiveFailures int
+	validationFailures  int
+	lastError           error
+	retryAt             time.Time
+}
+
+func newRelayHealth(policy HealthPolicy) *relayHealth {
+	return &relayHealth{policy: policy, now: time.Now}
+}
+
+// allow reports whether a request may be sent to the relay, moving an open
+// circuit to half-open once its cooldown has passed. A request let through
+// must be followed by observe or abandon.
+func (h *relayHealth) allow() error {
+	if h == nil {
+		return nil
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.state == CircuitOpen && !h.now().Before(h.retryAt) {
+		h.state = CircuitHalfOpen
+	}
+	switch {
+	case h.state == CircuitOpen:
+		return fmt.Errorf("%w until %s", RelayCircuitOpen, h.retryAt.Format(time.RFC3339))
+	case h.state == CircuitHalfOpen && h.probing:
+		return fmt.Errorf("%w: probe in progress", RelayCircuitOpen)
+	case h.state == CircuitHalfOpen:
+		h.probing = true
+	}
+	return nil
+}
+
+// observe records the outcome of a request let through by allow and returns
+// a handle to its sample
+func (h *relayHealth) observe(latency time.Duration, failed bool, err error) sampleHandle {
+	if h == nil {
+		return sampleHandle{}
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	handle := sampleHandle{seq: h.observed, recorded: true, probe: h.state == CircuitHalfOpen}
+	h.observed++
+	if h.policy.Window > 0 {
+		sample := healthSample{latency: latency, failed: failed}
+		if len(h.samples) < h.policy.Window {
+			h.samples = append(h.samples, sample)
+		} else {
+			h.samples[handle.seq%uint64(h.policy.Window)] = sample
+		}
+	}
+
+	h.probing = false
+	if failed {
+		h.fail(err)
+		return handle
+	}
+	h.consecutiveFailures = 0
+	h.state = CircuitClosed
+	return handle
+}
+
+// abandon releases a request let through by allow without recording it
+func (h *relayHealth) abandon() {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.probing = false
+}
+
+// validationFailed turns the request of sample into a failure because the
+// proxy invoice it returned did not validate. A probe returning an invalid
+// proxy invoice opens the circuit again, as a failed probe does.
+func (h *relayHealth) validationFailed(sample sampleHandle, err error) {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.validationFailures++
+	if window := uint64(h.policy.Window); sample.recorded && window > 0 && h.observed-sample.seq <= window {
+		h.samples[sample.seq%window].failed = true
+	}
+	if sample.probe {
+		h.state = CircuitHalfOpen
+	}
+	h.fail(err)
+}
+
+// fail counts a failure and opens the circuit once the threshold is
+// reached, or straight away when a probe failed. The caller holds h.mu.
+func (h *relayHealth) fail(err error) {
+	h.consecutiveFailures++
+	h.lastError = err
+	threshold := h.policy.FailureThreshold
+	if threshold > 0 && (h.state == CircuitHalfOpen || h.consecutiveFailures >= threshold) {
+		h.state = CircuitOpen
+		h.retryAt = h.now().Add(h.policy.Cooldown)
+	}
+}
+
+// snapshot returns the current health of the relay
+func (h *relayHealth) snapshot() RelayHealth {
+	if h == nil {
+		return RelayHealth{}
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	health := RelayHealth{
+		State:               h.state,
+		ConsecutiveFailures: h.consecutiveFailures,
+		Requests:            len(h.samples),
+		ValidationFailures:  h.validationFailures,
+		LastError:           h.lastError,
+	}
+	if h.state == CircuitOpen {
+		health.RetryAt = h.retryAt
+	}
+	if len(h.samples) == 0 {
+		return health
+	}
+
+	latencies := make([]time.Duration, len(h.samples))
+	failures := 0
+	for i, s := range h.samples {
+		latencies[i] = s.latency
+		if s.failed {
+			failures++
+		}
+	}
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	health.ErrorRate = float64(failures) / float64(len(h.samples))
+	health.LatencyP50 = percentile(latencies, 50)
+	health.LatencyP90 = percentile(latencies, 90)
+	health.LatencyP99 = percentile(latencies, 99)
+	return health
+}
+
+// percentile returns the nearest-rank percentile p of sorted latencies
+func percentile(sorted []time.Duration, p int) time.Duration {
+	rank := (p*len(sorted) + 99) / 100
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
+
+// WithHealthPolicy replaces the client's health policy and resets its
+// health state
+func (x *LNProxy) WithHealthPolicy(policy HealthPolicy) *LNProxy {
+	x.health = newRelayHealth(policy)
+	return x
+}
+
+// Health returns a snapshot of the relay's health. Clients not created by
+// NewLNProxy or WithHealthPolicy do not track health and always report a
+// closed circuit.
+func (x *LNProxy) Health() RelayHealth {
+	health := x.health.snapshot()
+	health.URL = x.URL.String()
+	return health
+}
+
+// Health returns a health snapshot of every relay in the pool, in order
+func (p *RelayPool) Health() []RelayHealth {
+	health := make([]RelayHealth, len(p.Relays))
+	for i, relay := range p.Relays {
+		health[i] = relay.Health()
+	}
+	return health
+}
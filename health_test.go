@@ -0,0 +1,159 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	failing := true
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if failing {
+			w.WriteHeader(http.StatusBadGateway)
+			json.NewEncoder(w).Encode(map[string]string{"status": "ERROR", "reason": "upstream down"})
+			return
+		}
+		// A healthy relay rejecting the invoice
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"status": "ERROR", "reason": "invoice expired"})
+	}))
+	defer server.Close()
+
+	now := time.Unix(1700000000, 0)
+	x := newTestClient(server.URL).WithHealthPolicy(HealthPolicy{FailureThreshold: 2, Cooldown: time.Minute, Window: 10})
+	x.health.now = func() time.Time { return now }
+
+	for i := 0; i < 2; i++ {
+		if _, err := x.RequestProxy("test-invoice", 1500); !errors.Is(err, LNProxyError) {
+			t.Fatalf("Expected LNProxyError, got %v", err)
+		}
+	}
+	health := x.Health()
+	if health.State != CircuitOpen || health.ConsecutiveFailures != 2 || !health.RetryAt.Equal(now.Add(time.Minute)) {
+		t.Fatalf("Expected open circuit after 2 failures, got %+v", health)
+	}
+	if _, err := x.RequestProxy("test-invoice", 1500); !errors.Is(err, RelayCircuitOpen) {
+		t.Errorf("Expected RelayCircuitOpen, got %v", err)
+	}
+	if requests != 2 {
+		t.Errorf("Expected the open circuit to keep requests from the relay, got %d requests", requests)
+	}
+
+	// A failed probe opens the circuit again straight away
+	now = now.Add(time.Minute)
+	x.RequestProxy("test-invoice", 1500)
+	if health := x.Health(); health.State != CircuitOpen || requests != 3 {
+		t.Fatalf("Expected failed probe to reopen the circuit, got %+v after %d requests", health, requests)
+	}
+
+	// A successful probe closes it
+	now = now.Add(time.Minute)
+	failing = false
+	if _, err := x.RequestProxy("test-invoice", 1500); errors.Is(err, RelayCircuitOpen) {
+		t.Fatalf("Expected probe to reach the relay, got %v", err)
+	}
+	health = x.Health()
+	if health.State != CircuitClosed || health.ConsecutiveFailures != 0 {
+		t.Errorf("Expected closed circuit after successful probe, got %+v", health)
+	}
+	if health.Requests != 4 || health.ErrorRate != 0.75 {
+		t.Errorf("Expected 3 of 4 requests failed, got %d requests at %.2f", health.Requests, health.ErrorRate)
+	}
+	if health.LatencyP50 <= 0 || health.LatencyP50 > health.LatencyP90 || health.LatencyP90 > health.LatencyP99 {
+		t.Errorf("Expected ordered latency percentiles, got %s %s %s", health.LatencyP50, health.LatencyP90, health.LatencyP99)
+	}
+	if health.URL != server.URL {
+		t.Errorf("Expected URL %s, got %s", server.URL, health.URL)
+	}
+}
+
+func TestRelayPoolHealth(t *testing.T) {
+	invoice := mustEncode(t, testInvoice(), testKey)
+	cheating := newTestRelay(t, func(p *Invoice) { p.PaymentHash = make([]byte, 32) }).
+		WithHealthPolicy(HealthPolicy{FailureThreshold: 1, Cooldown: time.Hour, Window: 10})
+	good := newTestRelay(t, honest)
+	pool := NewRelayPool(cheating, good).WithLogger(NewLogger(LevelError, io.Discard))
+
+	if _, err := pool.RequestProxy(invoice, 0); err != nil {
+		t.Fatalf("RequestProxy failed: %v", err)
+	}
+	health := pool.Health()
+	if health[0].ValidationFailures != 1 || health[0].State != CircuitOpen || !errors.Is(health[0].LastError, PaymentHashMismatch) {
+		t.Errorf("Expected the cheating relay's circuit to open, got %+v", health[0])
+	}
+	if health[1].State != CircuitClosed || health[1].Requests != 1 || health[1].ErrorRate != 0 {
+		t.Errorf("Expected a healthy second relay, got %+v", health[1])
+	}
+
+	result, err := pool.RequestProxy(invoice, 0)
+	if err != nil || len(result.Skipped) != 1 || !errors.Is(result.Skipped[0].Err, RelayCircuitOpen) {
+		t.Fatalf("Expected the open relay to be skipped, got %+v, %v", result, err)
+	}
+	if health := cheating.Health(); health.Requests != 1 {
+		t.Errorf("Expected no further request to the open relay, got %d", health.Requests)
+	}
+}
+
+func TestValidationFailed(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	h := newRelayHealth(HealthPolicy{FailureThreshold: 3, Cooldown: time.Minute, Window: 2})
+	h.now = func() time.Time { return now }
+
+	// Concurrent requests: the invalid proxy invoice came from the first
+	first := h.observe(time.Millisecond, false, nil)
+	h.observe(time.Millisecond, false, nil)
+	h.validationFailed(first, PaymentHashMismatch)
+	if !h.samples[0].failed || h.samples[1].failed {
+		t.Errorf("Expected only the first sample to fail, got %+v", h.samples)
+	}
+
+	// A sample that has left the window is not touched
+	h.observe(time.Millisecond, false, nil)
+	h.observe(time.Millisecond, false, nil)
+	h.validationFailed(first, PaymentHashMismatch)
+	if h.samples[0].failed || h.samples[1].failed {
+		t.Errorf("Expected samples in the window to be untouched, got %+v", h.samples)
+	}
+	if health := h.snapshot(); health.ValidationFailures != 2 || health.State != CircuitClosed {
+		t.Errorf("Expected 2 validation failures on a closed circuit, got %+v", health)
+	}
+
+	// A probe returning an invalid proxy invoice reopens the circuit
+	for i := 0; i < 3; i++ {
+		h.observe(time.Millisecond, true, LNProxyError)
+	}
+	now = now.Add(time.Minute)
+	if err := h.allow(); err != nil {
+		t.Fatalf("Expected the probe to be let through, got %v", err)
+	}
+	probe := h.observe(time.Millisecond, false, nil)
+	if !probe.probe || h.state != CircuitClosed {
+		t.Fatalf("Expected a successful probe closing the circuit, got %+v in state %s", probe, h.state)
+	}
+	h.validationFailed(probe, PaymentHashMismatch)
+	if health := h.snapshot(); health.State != CircuitOpen || !health.RetryAt.Equal(now.Add(time.Minute)) {
+		t.Errorf("Expected the invalid probe to reopen the circuit, got %+v", health)
+	}
+}
+
+func TestPercentile(t *testing.T) {
+	sorted := make([]time.Duration, 100)
+	for i := range sorted {
+		sorted[i] = time.Duration(i+1) * time.Millisecond
+	}
+	for p, want := range map[int]time.Duration{50: 50 * time.Millisecond, 90: 90 * time.Millisecond, 99: 99 * time.Millisecond} {
+		if got := percentile(sorted, p); got != want {
+			t.Errorf("percentile(%d) = %s, expected %s", p, got, want)
+		}
+	}
+	if got := percentile(sorted[:1], 99); got != time.Millisecond {
+		t.Errorf("percentile of one sample = %s", got)
+	}
+}
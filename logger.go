@@ -45,6 +45,10 @@ type Logger struct {
 }
 
 var (
+	// outputMu serialises writes from all loggers, since loggers derived
+	// with WithPrefix or WithComponent share their output
+	outputMu sync.Mutex
+
 	// defaultLogger is the global logger instance
 	defaultLogger     *Logger
 	defaultLoggerOnce sync.Once
@@ -134,7 +138,9 @@ func (l *Logger) log(level LogLevel, format string, args ...interface{}) {
 	logLine := fmt.Sprintf("%s [%s] %s%s%s\n", timestamp, level.String(), prefix, component, message)
 	
 	// We don't check for errors here as there's not much we can do if logging fails
+	outputMu.Lock()
 	_, _ = io.WriteString(l.output, logLine)
+	outputMu.Unlock()
 }
 
 // Debug logs a debug message
@@ -0,0 +1,81 @@
+package client
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+)
+
+// Network identifies the chain an invoice is payable on by its BOLT11
+// currency prefix.
+type Network string
+
+const (
+	Mainnet Network = "bc"
+	Testnet Network = "tb"
+	Signet  Network = "tbs"
+	Regtest Network = "bcrt"
+	Simnet  Network = "sb"
+)
+
+var (
+	UnknownNetwork    = errors.New("unknown invoice network")
+	NetworkNotAllowed = errors.New("invoice network not allowed")
+	NetworkMismatch   = errors.New("proxy invoice is for a different network")
+)
+
+// knownNetworks is ordered so that longer prefixes are tried first.
+var knownNetworks = []Network{Regtest, Signet, Mainnet, Testnet, Simnet}
+
+// String returns a human-readable name for the network.
+func (n Network) String() string {
+	switch n {
+	case Mainnet:
+		return "mainnet"
+	case Testnet:
+		return "testnet"
+	case Signet:
+		return "signet"
+	case Regtest:
+		return "regtest"
+	case Simnet:
+		return "simnet"
+	default:
+		return "unknown"
+	}
+}
+
+// parseHRP splits the human-readable part of an invoice into its network
+// and amount in millisatoshis.
+func parseHRP(hrp []byte) (Network, uint64, error) {
+	if !bytes.HasPrefix(hrp, []byte("ln")) {
+		return "", 0, UnknownNetwork
+	}
+	hrp = hrp[2:]
+	for _, network := range knownNetworks {
+		rest, ok := bytes.CutPrefix(hrp, []byte(network))
+		if !ok || (len(rest) > 0 && (rest[0] < '0' || rest[0] > '9')) {
+			continue
+		}
+		if len(rest) == 0 {
+			return network, 0, nil
+		}
+		amountMsat, err := parseAmount(rest)
+		return network, amountMsat, err
+	}
+	return "", 0, UnknownNetwork
+}
+
+// DetectNetwork returns the network of an invoice from its human-readable
+// part, without decoding the rest of it.
+func DetectNetwork(invoice string) (Network, error) {
+	pos := strings.LastIndexByte(invoice, '1')
+	if pos == -1 {
+		return "", UnknownNetwork
+	}
+	network, _, err := parseHRP(bytes.ToLower([]byte(invoice[:pos])))
+	if errors.Is(err, UnknownNetwork) {
+		return "", err
+	}
+	return network, nil
+}
@@ -0,0 +1,64 @@
+package client
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestDetectNetwork(t *testing.T) {
+	tests := []struct {
+		invoice string
+		want    Network
+	}{
+		{"lnbc1pvjluez", Mainnet},
+		{"lnbc2500u1pvjluez", Mainnet},
+		{"LNBC2500U1PVJLUEZ", Mainnet},
+		{"lntb1pvjluez", Testnet},
+		{"lntb20m1pvjluez", Testnet},
+		{"lntbs1pvjluez", Signet},
+		{"lntbs5n1pvjluez", Signet},
+		{"lnbcrt1pvjluez", Regtest},
+		{"lnbcrt10u1pvjluez", Regtest},
+		{"lnsb1pvjluez", Simnet},
+	}
+	for _, tt := range tests {
+		got, err := DetectNetwork(tt.invoice)
+		if err != nil || got != tt.want {
+			t.Errorf("DetectNetwork(%q) = %s, %v; want %s", tt.invoice, got, err, tt.want)
+		}
+	}
+
+	for _, invoice := range []string{"test-invoice", "lnxy1pvjluez", "bc1qar0srrr7xfkvy5l643lydnw9re59gtzzwf5mdq"} {
+		if _, err := DetectNetwork(invoice); !errors.Is(err, UnknownNetwork) {
+			t.Errorf("DetectNetwork(%q): expected UnknownNetwork, got %v", invoice, err)
+		}
+	}
+}
+
+func TestDecodeInvoiceNetworks(t *testing.T) {
+	hash := taggedField('p', bytes.Repeat([]byte{0x01}, 32))
+	for _, tt := range []struct {
+		hrp    string
+		want   Network
+		amount uint64
+	}{
+		{"lntb20m", Testnet, 2_000_000_000},
+		{"lntbs", Signet, 0},
+		{"lnbcrt10u", Regtest, 1_000_000},
+		{"lnsb1n", Simnet, 100},
+	} {
+		invoice := rawInvoice(testKey, tt.hrp, 1496314658, hash)
+		got, err := DecodeInvoice([]byte(invoice))
+		if err != nil {
+			t.Fatalf("DecodeInvoice(%s) failed: %v", tt.hrp, err)
+		}
+		if got.Network != tt.want || got.AmountMsat != tt.amount {
+			t.Errorf("DecodeInvoice(%s) = %s %d msat; want %s %d msat", tt.hrp, got.Network, got.AmountMsat, tt.want, tt.amount)
+		}
+		parts, err := ParseInvoice([]byte(invoice))
+		if err != nil || parts.Network != tt.want || parts.AmountMsat != tt.amount {
+			t.Errorf("ParseInvoice(%s) = %v, %v; want %s %d msat", tt.hrp, parts, err, tt.want, tt.amount)
+		}
+	}
+}
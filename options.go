@@ -0,0 +1,43 @@
+package client
+
+import (
+	"errors"
+)
+
+var InvalidRequestOptions = errors.New("invalid request options")
+
+// RequestOptions adjusts the proxy invoice a relay is asked for. The zero
+// value requests a proxy invoice with the original's description.
+type RequestOptions struct {
+	// Description asks the relay to put this description on the proxy
+	// invoice in place of the original's.
+	Description string
+	// DescriptionHash asks the relay for a proxy invoice committing to this
+	// 32-byte description hash, as LNURL-pay and Lightning Address servers
+	// need for their metadata.
+	DescriptionHash []byte
+}
+
+// check rejects options no relay can honour
+func (o RequestOptions) check() error {
+	switch {
+	case o.DescriptionHash != nil && len(o.DescriptionHash) != 32:
+		return errors.Join(InvalidRequestOptions, errors.New("description hash must be 32 bytes"))
+	case o.DescriptionHash != nil && o.Description != "":
+		return errors.Join(InvalidRequestOptions, errors.New("description and description hash are mutually exclusive"))
+	}
+	return nil
+}
+
+// description returns the description and description hash the proxy
+// invoice for original is expected to carry
+func (o RequestOptions) description(original *Invoice) *Invoice {
+	switch {
+	case o.DescriptionHash != nil:
+		return &Invoice{DescriptionHash: o.DescriptionHash}
+	case o.Description != "":
+		return &Invoice{Description: o.Description}
+	default:
+		return original
+	}
+}
@@ -0,0 +1,129 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// newDescriptionRelay starts a relay honouring description and
+// description_hash overrides
+func newDescriptionRelay(t *testing.T) *LNProxy {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Invoice         string `json:"invoice"`
+			RoutingMsat     uint64 `json:"routing_msat,string"`
+			Description     string `json:"description"`
+			DescriptionHash string `json:"description_hash"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		original, err := DecodeInvoice([]byte(req.Invoice))
+		if err != nil {
+			t.Errorf("Relay cannot decode invoice: %v", err)
+			return
+		}
+		proxy := testProxyInvoice(original, req.RoutingMsat)
+		switch {
+		case req.DescriptionHash != "":
+			proxy.Description, proxy.DescriptionHash = "", mustHex(req.DescriptionHash)
+		case req.Description != "":
+			proxy.Description = req.Description
+		}
+		proxy_invoice, _ := EncodeInvoice(proxy, relayKey)
+		json.NewEncoder(w).Encode(map[string]string{"proxy_invoice": proxy_invoice})
+	}))
+	t.Cleanup(server.Close)
+	return newTestClient(server.URL)
+}
+
+func TestRequestProxyWithOptions(t *testing.T) {
+	invoice := mustEncode(t, testInvoice(), testKey)
+	relay := newDescriptionRelay(t)
+	metadataHash := bytes.Repeat([]byte{0xab}, 32)
+
+	tests := []struct {
+		name string
+		opts RequestOptions
+		want string
+	}{
+		{"description hash", RequestOptions{DescriptionHash: metadataHash}, "hash " + hex.EncodeToString(metadataHash)},
+		{"description", RequestOptions{Description: "Pay to satoshi@example.com"}, `"Pay to satoshi@example.com"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			proxyInvoice, err := relay.RequestProxyWithOptions(context.Background(), invoice, 0, tt.opts)
+			if err != nil {
+				t.Fatalf("RequestProxyWithOptions failed: %v", err)
+			}
+			report := relay.AuditProxyInvoiceWithOptions(invoice, proxyInvoice, 0, tt.opts)
+			if !report.OK() {
+				t.Errorf("Expected proxy invoice to validate, got %v", report.Err())
+			}
+			if check, _ := report.Check(CheckDescription); check.Expected != tt.want || check.Actual != tt.want {
+				t.Errorf("Expected description %s, got %+v", tt.want, check)
+			}
+			if _, err := relay.ValidateProxyInvoice(invoice, proxyInvoice, 0); !errors.Is(err, DescriptionMismatch) {
+				t.Errorf("Expected DescriptionMismatch without the options, got %v", err)
+			}
+		})
+	}
+
+	// A relay ignoring the override
+	proxyInvoice, err := relay.RequestProxy(invoice, 0)
+	if err != nil {
+		t.Fatalf("RequestProxy failed: %v", err)
+	}
+	if _, err := relay.ValidateProxyInvoiceWithOptions(invoice, proxyInvoice, 0, tests[0].opts); !errors.Is(err, DescriptionMismatch) {
+		t.Errorf("Expected DescriptionMismatch for an ignored override, got %v", err)
+	}
+}
+
+func TestRequestOptionsInvalid(t *testing.T) {
+	relay := newFailingRelay(t, http.StatusInternalServerError, "unexpected request")
+	for _, opts := range []RequestOptions{
+		{DescriptionHash: []byte{1, 2, 3}},
+		{Description: "coffee", DescriptionHash: make([]byte, 32)},
+	} {
+		if _, err := relay.RequestProxyWithOptions(context.Background(), "test-invoice", 1500, opts); !errors.Is(err, InvalidRequestOptions) {
+			t.Errorf("Expected InvalidRequestOptions for %+v, got %v", opts, err)
+		}
+	}
+}
+
+func TestRelayPoolWithOptions(t *testing.T) {
+	invoice := mustEncode(t, testInvoice(), testKey)
+	ignoring := newTestRelay(t, honest)
+	honouring := newDescriptionRelay(t)
+	opts := RequestOptions{DescriptionHash: bytes.Repeat([]byte{0xab}, 32)}
+	pool := NewRelayPool(ignoring, honouring).WithLogger(NewLogger(LevelError, io.Discard))
+
+	result, err := pool.RequestProxyWithOptions(context.Background(), invoice, 0, opts)
+	if err != nil {
+		t.Fatalf("RequestProxyWithOptions failed: %v", err)
+	}
+	if result.Relay != honouring || len(result.Skipped) != 1 || !errors.Is(result.Skipped[0].Err, DescriptionMismatch) {
+		t.Errorf("Expected failover to the relay honouring the override, got %+v", result)
+	}
+	budget, _ := ImpliedRoutingBudget(invoice, result.ProxyInvoice)
+	if ok, err := ValidateProxyInvoiceWithOptions(invoice, result.ProxyInvoice, budget, opts); !ok {
+		t.Errorf("Expected the proxy invoice to validate with the options, got %v", err)
+	}
+	if _, err := ValidateProxyInvoice(invoice, result.ProxyInvoice, budget); !errors.Is(err, DescriptionMismatch) {
+		t.Errorf("Expected DescriptionMismatch without the options, got %v", err)
+	}
+
+	result, err = pool.RaceProxy(context.Background(), invoice, 0, RaceOptions{Request: opts})
+	if err != nil {
+		t.Fatalf("RaceProxy failed: %v", err)
+	}
+	if result.Relay != honouring || !errors.Is(result.Skipped[0].Err, DescriptionMismatch) {
+		t.Errorf("Expected the race to go to the relay honouring the override, got %+v", result)
+	}
+}
@@ -1,17 +1,27 @@
 package client
 
 import (
-	"bytes"
 	"errors"
+	"fmt"
+	"math"
 	"regexp"
 	"strconv"
 )
 
 var charSet = []byte("qpzry9x8gf2tvdw0s3jn54khce6mua7l")
 
-var isBech32 = regexp.MustCompile("^lnbc(?:[0-9]+[pnum])?1[qpzry9x8gf2tvdw0s3jn54khce6mua7l]+$")
+var (
+	ErrInvalidInvoice  = errors.New("invalid invoice")
+	ErrInvoiceTooShort = errors.New("invoice too short")
+	ErrTruncatedField  = errors.New("truncated invoice field")
+	ErrInvalidAmount   = errors.New("invalid invoice amount")
+	ErrInvalidField    = errors.New("invalid invoice field")
+)
+
+var isBech32 = regexp.MustCompile("^ln(?:bcrt|tbs|bc|tb|sb)(?:[0-9]+[pnum])?1[qpzry9x8gf2tvdw0s3jn54khce6mua7l]+$")
 
 type InvoiceParts struct {
+	Network         Network
 	AmountMsat      uint64
 	PaymentHash     []byte
 	Description     []byte
@@ -19,72 +29,82 @@ type InvoiceParts struct {
 	Signature       []byte
 }
 
+// ParseInvoice splits a BOLT11 invoice into the parts a proxy request needs,
+// without decoding them or verifying the signature. PaymentHash, Description
+// and Signature are left as bech32 characters.
 func ParseInvoice(invoice []byte) (*InvoiceParts, error) {
 	logger := DefaultLogger().WithComponent("InvoiceParser")
-	logger.Debug("Parsing invoice: %s", string(invoice[:min(len(invoice), 40)]) + "...")
-	
-	invoice = bytes.ToLower(invoice)
-	pos := bytes.LastIndexByte(invoice, byte('1'))
-	if pos == -1 || !isBech32.Match(invoice) {
-		logger.Error("Invalid invoice format")
-		return nil, errors.New("invalid invoice")
+	logger.Debug("Parsing invoice: %s", string(invoice[:min(len(invoice), 40)])+"...")
+
+	raw, err := splitInvoice(invoice, logger)
+	if err != nil {
+		return nil, err
 	}
 
-	var invoice_parts InvoiceParts
-	var err error
-	if pos > 4 {
-		amountStr := string(invoice[4 : pos-1])
-		logger.Debug("Parsing amount from: %s", amountStr)
-		invoice_parts.AmountMsat, err = strconv.ParseUint(amountStr, 10, 64)
-		if err != nil {
-			logger.Error("Failed to parse amount: %v", err)
-			return nil, err
-		}
-		
-		unit := invoice[pos-1]
-		logger.Debug("Amount unit: %c", unit)
-		switch unit {
+	invoice_parts := InvoiceParts{Network: raw.network, AmountMsat: raw.amountMsat}
+	logger.Debug("Calculated amount: %d msat", invoice_parts.AmountMsat)
+	for _, f := range raw.fields {
+		switch f.tag {
 		case byte('p'):
-			invoice_parts.AmountMsat /= 10
-		case byte('n'):
-			invoice_parts.AmountMsat *= 100
-		case byte('u'):
-			invoice_parts.AmountMsat *= 100_000
-		case byte('m'):
-			invoice_parts.AmountMsat *= 100_000_000
-		}
-		logger.Debug("Calculated amount: %d msat", invoice_parts.AmountMsat)
-	}
-	
-	logger.Debug("Parsing invoice data fields")
-	for i := pos + 8; i < len(invoice); {
-		data_length := bytes.Index(charSet, invoice[i+1:i+2])*32 + bytes.Index(charSet, invoice[i+2:i+3])
-		logger.Debug("Found field type %c with length %d", invoice[i], data_length)
-		
-		if invoice[i] == byte('p') {
-			invoice_parts.PaymentHash = invoice[i+3 : i+3+data_length]
+			invoice_parts.PaymentHash = toChars(f.data)
 			logger.Debug("Payment hash found (length: %d)", len(invoice_parts.PaymentHash))
-		}
-		if invoice[i] == byte('d') {
+		case byte('d'):
 			invoice_parts.DescriptionHash = false
-			invoice_parts.Description = invoice[i+3 : i+3+data_length]
+			invoice_parts.Description = toChars(f.data)
 			logger.Debug("Description found: %s", string(invoice_parts.Description))
-		}
-		if invoice[i] == byte('h') {
+		case byte('h'):
 			invoice_parts.DescriptionHash = true
-			invoice_parts.Description = invoice[i+3 : i+3+data_length]
+			invoice_parts.Description = toChars(f.data)
 			logger.Debug("Description hash found (length: %d)", len(invoice_parts.Description))
 		}
-		i += 3 + data_length
 	}
-	
-	invoice_parts.Signature = invoice[len(invoice)-110 : len(invoice)-6]
+
+	invoice_parts.Signature = toChars(raw.signature)
 	logger.Debug("Signature extracted (length: %d)", len(invoice_parts.Signature))
-	
+
 	logger.Debug("Invoice parsing complete")
 	return &invoice_parts, nil
 }
 
+// parseAmount converts the amount part of a human-readable prefix, digits
+// optionally followed by a multiplier, into millisatoshis.
+func parseAmount(amount []byte) (uint64, error) {
+	if len(amount) == 0 {
+		return 0, ErrInvalidAmount
+	}
+	digits, multiplier := amount[:len(amount)-1], amount[len(amount)-1]
+	if multiplier >= '0' && multiplier <= '9' {
+		digits, multiplier = amount, 0
+	}
+	value, err := strconv.ParseUint(string(digits), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %w", ErrInvalidAmount, err)
+	}
+
+	var scale uint64
+	switch multiplier {
+	case byte('p'):
+		if value%10 != 0 {
+			return 0, fmt.Errorf("%w: sub-millisatoshi amount", ErrInvalidAmount)
+		}
+		return value / 10, nil
+	case byte('n'):
+		scale = 100
+	case byte('u'):
+		scale = 100_000
+	case byte('m'):
+		scale = 100_000_000
+	case 0:
+		scale = 100_000_000_000
+	default:
+		return 0, fmt.Errorf("%w: unknown multiplier %c", ErrInvalidAmount, multiplier)
+	}
+	if value > math.MaxUint64/scale {
+		return 0, fmt.Errorf("%w: amount overflows", ErrInvalidAmount)
+	}
+	return value * scale, nil
+}
+
 // Helper function for min of two integers
 func min(a, b int) int {
 	if a < b {
@@ -1,20 +1,25 @@
 package client
 
 import (
+	"errors"
 	"fmt"
+	"io"
+	"os"
 	"reflect"
+	"strings"
 	"testing"
 )
 
 func invoicePartsToString(i *InvoiceParts) string {
 	return fmt.Sprintf(`InvoiceParts{
+	Network: %s,
 	AmountMsat: %d,
 	PaymentHash: %s,
 	Description: %s,
 	DescriptionHash: %v,
 	Signature: %s,
 }
-`, i.AmountMsat, string(i.PaymentHash), string(i.Description), i.DescriptionHash, string(i.Signature),
+`, i.Network, i.AmountMsat, string(i.PaymentHash), string(i.Description), i.DescriptionHash, string(i.Signature),
 	)
 }
 
@@ -25,6 +30,7 @@ func TestParseInvoice(t *testing.T) {
 
 	invoice = "lnbc1pvjluezsp5zyg3zyg3zyg3zyg3zyg3zyg3zyg3zyg3zyg3zyg3zyg3zyg3zygspp5qqqsyqcyq5rqwzqfqqqsyqcyq5rqwzqfqqqsyqcyq5rqwzqfqypqdpl2pkx2ctnv5sxxmmwwd5kgetjypeh2ursdae8g6twvus8g6rfwvs8qun0dfjkxaq9qrsgq357wnc5r2ueh7ck6q93dj32dlqnls087fxdwk8qakdyafkq3yap9us6v52vjjsrvywa6rt52cm9r9zqt8r2t7mlcwspyetp5h2tztugp9lfyql"
 	want = &InvoiceParts{
+		Network:         Mainnet,
 		AmountMsat:      0,
 		PaymentHash:     []byte("qqqsyqcyq5rqwzqfqqqsyqcyq5rqwzqfqqqsyqcyq5rqwzqfqypq"),
 		Description:     []byte("2pkx2ctnv5sxxmmwwd5kgetjypeh2ursdae8g6twvus8g6rfwvs8qun0dfjkxaq"),
@@ -38,6 +44,7 @@ func TestParseInvoice(t *testing.T) {
 
 	invoice = "lnbc2500u1pvjluezsp5zyg3zyg3zyg3zyg3zyg3zyg3zyg3zyg3zyg3zyg3zyg3zyg3zygspp5qqqsyqcyq5rqwzqfqqqsyqcyq5rqwzqfqqqsyqcyq5rqwzqfqypqdq5xysxxatsyp3k7enxv4jsxqzpu9qrsgquk0rl77nj30yxdy8j9vdx85fkpmdla2087ne0xh8nhedh8w27kyke0lp53ut353s06fv3qfegext0eh0ymjpf39tuven09sam30g4vgpfna3rh"
 	want = &InvoiceParts{
+		Network:         Mainnet,
 		AmountMsat:      250000000,
 		PaymentHash:     []byte("qqqsyqcyq5rqwzqfqqqsyqcyq5rqwzqfqqqsyqcyq5rqwzqfqypq"),
 		Description:     []byte("xysxxatsyp3k7enxv4js"),
@@ -51,6 +58,7 @@ func TestParseInvoice(t *testing.T) {
 
 	invoice = "lnbc15u1p3xnhl2pp5jptserfk3zk4qy42tlucycrfwxhydvlemu9pqr93tuzlv9cc7g3sdqsvfhkcap3xyhx7un8cqzpgxqzjcsp5f8c52y2stc300gl6s4xswtjpc37hrnnr3c9wvtgjfuvqmpm35evq9qyyssqy4lgd8tj637qcjp05rdpxxykjenthxftej7a2zzmwrmrl70fyj9hvj0rewhzj7jfyuwkwcg9g2jpwtk3wkjtwnkdks84hsnu8xps5vsq4gj5hs"
 	want = &InvoiceParts{
+		Network:         Mainnet,
 		AmountMsat:      1500000,
 		PaymentHash:     []byte("jptserfk3zk4qy42tlucycrfwxhydvlemu9pqr93tuzlv9cc7g3s"),
 		Description:     []byte("vfhkcap3xyhx7un8"),
@@ -62,3 +70,102 @@ func TestParseInvoice(t *testing.T) {
 		t.Fatalf("\nwanted: %s\ngot: %s\n", invoicePartsToString(want), invoicePartsToString(got))
 	}
 }
+
+func TestParseInvoiceBadChecksum(t *testing.T) {
+	valid := "lnbc2500u1pvjluezsp5zyg3zyg3zyg3zyg3zyg3zyg3zyg3zyg3zyg3zyg3zyg3zyg3zygspp5qqqsyqcyq5rqwzqfqqqsyqcyq5rqwzqfqqqsyqcyq5rqwzqfqypqdq5xysxxatsyp3k7enxv4jsxqzpu9qrsgquk0rl77nj30yxdy8j9vdx85fkpmdla2087ne0xh8nhedh8w27kyke0lp53ut353s06fv3qfegext0eh0ymjpf39tuven09sam30g4vgpfna3rh"
+	corrupted := []string{
+		// single character typo in the description
+		strings.Replace(valid, "xysxxatsyp3k7enxv4js", "xysxxatsyp3k7enxv4jq", 1),
+		// altered amount in the human-readable part
+		strings.Replace(valid, "lnbc2500u", "lnbc2600u", 1),
+		// an amount that would not parse either, which must not mask the
+		// checksum failure
+		strings.Replace(valid, "lnbc2500u", "lnbc2555p", 1),
+		// damaged checksum
+		valid[:len(valid)-1] + "q",
+	}
+	for _, invoice := range corrupted {
+		if _, err := ParseInvoice([]byte(invoice)); !errors.Is(err, ErrBadChecksum) {
+			t.Errorf("ParseInvoice: expected ErrBadChecksum, got %v", err)
+		}
+		if _, err := DecodeInvoice([]byte(invoice)); !errors.Is(err, ErrBadChecksum) {
+			t.Errorf("DecodeInvoice: expected ErrBadChecksum, got %v", err)
+		}
+	}
+	if _, err := ParseInvoice([]byte(strings.ToUpper(valid))); err != nil {
+		t.Errorf("Upper-case invoice should parse, got %v", err)
+	}
+}
+
+// withChecksum replaces the checksum of invoice with a valid one, so that
+// malformed data reaches the field parser.
+func withChecksum(invoice string) string {
+	pos := strings.LastIndexByte(invoice, '1')
+	if pos == -1 || len(invoice)-pos-1 < checksumWords {
+		return invoice
+	}
+	hrp, data := invoice[:pos], invoice[pos+1:len(invoice)-checksumWords]
+	words, err := fromChars([]byte(data))
+	if err != nil {
+		return invoice
+	}
+	return hrp + "1" + data + string(toChars(createChecksum([]byte(hrp), words)))
+}
+
+func TestParseInvoiceMalformed(t *testing.T) {
+	valid := "lnbc2500u1pvjluezsp5zyg3zyg3zyg3zyg3zyg3zyg3zyg3zyg3zyg3zyg3zyg3zyg3zygspp5qqqsyqcyq5rqwzqfqqqsyqcyq5rqwzqfqqqsyqcyq5rqwzqfqypqdq5xysxxatsyp3k7enxv4jsxqzpu9qrsgquk0rl77nj30yxdy8j9vdx85fkpmdla2087ne0xh8nhedh8w27kyke0lp53ut353s06fv3qfegext0eh0ymjpf39tuven09sam30g4vgpfna3rh"
+	signature := valid[len(valid)-signatureWords-checksumWords:]
+	tests := []struct {
+		name    string
+		invoice string
+		want    error
+	}{
+		{"empty", "", ErrInvalidInvoice},
+		{"prefix only", "lnbc1", ErrInvalidInvoice},
+		{"unknown network", withChecksum("lnxy1" + valid[10:]), ErrInvalidInvoice},
+		{"short", withChecksum("lnbc1qqqqqqqqqqqqqqqqqqqqqq"), ErrInvoiceTooShort},
+		{"no signature", withChecksum(valid[:len(valid)-signatureWords]), ErrTruncatedField},
+		{"truncated header", withChecksum("lnbc1pvjluezpp" + signature), ErrTruncatedField},
+		{"overlong field", withChecksum("lnbc1pvjluezpp5qqqq" + signature), ErrTruncatedField},
+		{"sub-millisatoshi amount", withChecksum("lnbc2555p" + valid[9:]), ErrInvalidAmount},
+		{"overflowing amount", withChecksum("lnbc99999999999999999m" + valid[9:]), ErrInvalidAmount},
+	}
+	for _, tt := range tests {
+		if _, err := ParseInvoice([]byte(tt.invoice)); !errors.Is(err, tt.want) {
+			t.Errorf("%s: ParseInvoice expected %v, got %v", tt.name, tt.want, err)
+		}
+		if _, err := DecodeInvoice([]byte(tt.invoice)); !errors.Is(err, tt.want) {
+			t.Errorf("%s: DecodeInvoice expected %v, got %v", tt.name, tt.want, err)
+		}
+	}
+}
+
+func FuzzParseInvoice(f *testing.F) {
+	SetGlobalOutput(io.Discard)
+	defer SetGlobalOutput(os.Stderr)
+
+	for _, seed := range []string{
+		"",
+		"lnbc1",
+		"lnbc1pvjluezpp",
+		"lnbcrt10u1qqqqqqq",
+		"lnbc1pvjluezsp5zyg3zyg3zyg3zyg3zyg3zyg3zyg3zyg3zyg3zyg3zyg3zyg3zygspp5qqqsyqcyq5rqwzqfqqqsyqcyq5rqwzqfqqqsyqcyq5rqwzqfqypqdpl2pkx2ctnv5sxxmmwwd5kgetjypeh2ursdae8g6twvus8g6rfwvs8qun0dfjkxaq9qrsgq357wnc5r2ueh7ck6q93dj32dlqnls087fxdwk8qakdyafkq3yap9us6v52vjjsrvywa6rt52cm9r9zqt8r2t7mlcwspyetp5h2tztugp9lfyql",
+		"lnbc2500u1pvjluezsp5zyg3zyg3zyg3zyg3zyg3zyg3zyg3zyg3zyg3zyg3zyg3zyg3zygspp5qqqsyqcyq5rqwzqfqqqsyqcyq5rqwzqfqqqsyqcyq5rqwzqfqypqdq5xysxxatsyp3k7enxv4jsxqzpu9qrsgquk0rl77nj30yxdy8j9vdx85fkpmdla2087ne0xh8nhedh8w27kyke0lp53ut353s06fv3qfegext0eh0ymjpf39tuven09sam30g4vgpfna3rh",
+		"lnbc15u1p3xnhl2pp5jptserfk3zk4qy42tlucycrfwxhydvlemu9pqr93tuzlv9cc7g3sdqsvfhkcap3xyhx7un8cqzpgxqzjcsp5f8c52y2stc300gl6s4xswtjpc37hrnnr3c9wvtgjfuvqmpm35evq9qyyssqy4lgd8tj637qcjp05rdpxxykjenthxftej7a2zzmwrmrl70fyj9hvj0rewhzj7jfyuwkwcg9g2jpwtk3wkjtwnkdks84hsnu8xps5vsq4gj5hs",
+	} {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, invoice string) {
+		// Also parse the input with a repaired checksum, as random mutations
+		// would otherwise almost never get past checksum verification.
+		for _, in := range []string{invoice, withChecksum(invoice)} {
+			parts, perr := ParseInvoice([]byte(in))
+			inv, derr := DecodeInvoice([]byte(in))
+			if perr == nil && derr == nil && parts.AmountMsat != inv.AmountMsat {
+				t.Errorf("ParseInvoice and DecodeInvoice disagree on amount: %d != %d", parts.AmountMsat, inv.AmountMsat)
+			}
+			DetectNetwork(in)
+		}
+	})
+}
@@ -0,0 +1,136 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+var RelayNotChosen = errors.New("relay answer not chosen")
+
+// RaceMode selects how RaceProxy picks among the answers of several relays
+type RaceMode int
+
+const (
+	// RaceCheapest waits for every relay, or until the latency budget runs
+	// out, and picks the valid proxy invoice with the lowest amount.
+	RaceCheapest RaceMode = iota
+	// RaceFirst picks the first valid proxy invoice to arrive.
+	RaceFirst
+)
+
+// RaceOptions configures RaceProxy
+type RaceOptions struct {
+	Mode RaceMode
+	// LatencyBudget bounds how long to wait for relays to answer. Relays
+	// that have not answered by then are abandoned. Zero waits as long as
+	// the context allows.
+	LatencyBudget time.Duration
+	// Request holds the description overrides every relay is asked for and
+	// every proxy invoice is validated against.
+	Request RequestOptions
+}
+
+// raceAnswer is the outcome of one relay's request in a race
+type raceAnswer struct {
+	index         int
+	proxy_invoice string
+	amountMsat    uint64
+	err           error
+}
+
+// RaceProxy asks every relay in the pool at the same time to wrap invoice
+// with the description overrides in opts.Request, validates each proxy
+// invoice with the relay's ValidateProxyInvoiceWithOptions and picks one
+// according to opts. Requests still running once a choice is made are
+// cancelled. Ties go to the relay earlier in the pool. The Skipped list of
+// the result covers every relay other than the chosen one, in pool order.
+func (p *RelayPool) RaceProxy(ctx context.Context, invoice string, routing_msat uint64, opts RaceOptions) (*PoolResult, error) {
+	raceCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	p.logger.Debug("Racing %d relays", len(p.Relays))
+	answers := make(chan raceAnswer, len(p.Relays))
+	for i, relay := range p.Relays {
+		go func(i int, relay *LNProxy) {
+			answer := raceAnswer{index: i}
+			answer.proxy_invoice, answer.err = requestValidProxy(raceCtx, relay, invoice, routing_msat, opts.Request)
+			if answer.err == nil {
+				proxy, err := DecodeInvoice([]byte(answer.proxy_invoice))
+				if err != nil {
+					answer.err = fmt.Errorf("%w: %w", InvalidProxyInvoice, err)
+				} else {
+					answer.amountMsat = proxy.AmountMsat
+				}
+			}
+			answers <- answer
+		}(i, relay)
+	}
+
+	var deadline <-chan time.Time
+	if opts.LatencyBudget > 0 {
+		timer := time.NewTimer(opts.LatencyBudget)
+		defer timer.Stop()
+		deadline = timer.C
+	}
+
+	results := make([]*raceAnswer, len(p.Relays))
+	best := -1
+	expired := false
+race:
+	for pending := len(p.Relays); pending > 0; pending-- {
+		select {
+		case answer := <-answers:
+			results[answer.index] = &answer
+			relay := p.Relays[answer.index]
+			if answer.err != nil {
+				p.logger.Warn("Relay %s failed: %v", relay.URL.String(), answer.err)
+				continue
+			}
+			p.logger.Debug("Relay %s offered %d msat", relay.URL.String(), answer.amountMsat)
+			if best == -1 || answer.amountMsat < results[best].amountMsat ||
+				(answer.amountMsat == results[best].amountMsat && answer.index < best) {
+				best = answer.index
+			}
+			if opts.Mode == RaceFirst {
+				break race
+			}
+		case <-deadline:
+			p.logger.Warn("Latency budget of %s exhausted", opts.LatencyBudget)
+			expired = true
+			break race
+		case <-ctx.Done():
+			p.logger.Warn("Race abandoned: %v", ctx.Err())
+			return nil, ctx.Err()
+		}
+	}
+	cancel()
+
+	var attempts []RelayAttempt
+	for i, relay := range p.Relays {
+		if i == best {
+			continue
+		}
+		var err error
+		switch {
+		case results[i] != nil && results[i].err != nil:
+			err = results[i].err
+		case results[i] != nil:
+			err = fmt.Errorf("%w: proxy amount %d msat", RelayNotChosen, results[i].amountMsat)
+		case expired:
+			err = &TimeoutError{URL: relay.URL.String(), Limit: opts.LatencyBudget, Err: context.DeadlineExceeded}
+		default:
+			err = fmt.Errorf("%w: cancelled after another relay answered", RelayNotChosen)
+		}
+		attempts = append(attempts, RelayAttempt{Relay: relay, Err: err})
+	}
+
+	if best == -1 {
+		p.logger.Error("No relay could wrap the invoice (%d tried)", len(attempts))
+		return nil, &PoolError{Attempts: attempts}
+	}
+	winner := p.Relays[best]
+	p.logger.Info("Relay %s won the race with %d msat", winner.URL.String(), results[best].amountMsat)
+	return &PoolResult{ProxyInvoice: results[best].proxy_invoice, Relay: winner, Skipped: attempts}, nil
+}
@@ -0,0 +1,121 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// newStuckRelay starts a relay that never answers. Each request it gives up
+// on because the client went away is reported on the returned channel.
+func newStuckRelay(t *testing.T) (*LNProxy, <-chan struct{}) {
+	t.Helper()
+	cancelled := make(chan struct{}, 16)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		<-r.Context().Done()
+		cancelled <- struct{}{}
+	}))
+	t.Cleanup(server.Close)
+	return newTestClient(server.URL), cancelled
+}
+
+func TestRaceProxyCheapest(t *testing.T) {
+	invoice := mustEncode(t, testInvoice(), testKey)
+	expensive := newTestRelay(t, honest)
+	expensive.BaseMsat = 5000
+	cheap := newTestRelay(t, honest)
+	middle := newTestRelay(t, honest)
+	middle.BaseMsat = 3000
+	failing := newFailingRelay(t, http.StatusInternalServerError, "internal error")
+
+	pool := NewRelayPool(expensive, failing, cheap, middle).WithLogger(NewLogger(LevelError, io.Discard))
+	result, err := pool.RaceProxy(context.Background(), invoice, 0, RaceOptions{})
+	if err != nil {
+		t.Fatalf("RaceProxy failed: %v", err)
+	}
+	if result.Relay != cheap {
+		t.Errorf("Expected the cheapest relay to win, got %s", result.Relay.URL.String())
+	}
+	proxy, _ := DecodeInvoice([]byte(result.ProxyInvoice))
+	if want := testInvoice().AmountMsat + cheap.RoutingBudget(testInvoice().AmountMsat); proxy == nil || proxy.AmountMsat != want {
+		t.Errorf("Expected proxy amount %d msat, got %+v", want, proxy)
+	}
+	if len(result.Skipped) != 3 {
+		t.Fatalf("Expected 3 skipped relays, got %d", len(result.Skipped))
+	}
+	for i, want := range []*LNProxy{expensive, failing, middle} {
+		if result.Skipped[i].Relay != want {
+			t.Errorf("Skipped relay %d is %s, expected %s", i, result.Skipped[i].Relay.URL.String(), want.URL.String())
+		}
+	}
+	if !errors.Is(result.Skipped[0].Err, RelayNotChosen) || !errors.Is(result.Skipped[2].Err, RelayNotChosen) {
+		t.Errorf("Expected RelayNotChosen for the dearer relays, got %v and %v", result.Skipped[0].Err, result.Skipped[2].Err)
+	}
+	if !errors.Is(result.Skipped[1].Err, LNProxyError) {
+		t.Errorf("Expected LNProxyError for the failing relay, got %v", result.Skipped[1].Err)
+	}
+
+	// Equal offers go to the relay earlier in the pool, even when it
+	// answers last
+	slow := newTestRelay(t, func(*Invoice) { time.Sleep(100 * time.Millisecond) })
+	fast := newTestRelay(t, honest)
+	slow.BaseMsat, fast.BaseMsat = 2000, 2000
+	for _, order := range [][]*LNProxy{{slow, fast}, {fast, slow}} {
+		pool = NewRelayPool(middle, order[0], order[1]).WithLogger(NewLogger(LevelError, io.Discard))
+		result, err = pool.RaceProxy(context.Background(), invoice, 0, RaceOptions{})
+		if err != nil {
+			t.Fatalf("RaceProxy failed: %v", err)
+		}
+		if result.Relay != order[0] {
+			t.Errorf("Expected %s, the earlier of two equal relays, to win, got %s", order[0].URL.String(), result.Relay.URL.String())
+		}
+		loser := result.Skipped[len(result.Skipped)-1]
+		if loser.Relay != order[1] || !errors.Is(loser.Err, RelayNotChosen) {
+			t.Errorf("Expected the later equal relay to be skipped as not chosen, got %+v", loser)
+		}
+	}
+}
+
+func TestRaceProxyFirst(t *testing.T) {
+	invoice := mustEncode(t, testInvoice(), testKey)
+	stuck, cancelled := newStuckRelay(t)
+	cheating := newTestRelay(t, func(p *Invoice) { p.PaymentHash = make([]byte, 32) })
+	good := newTestRelay(t, honest)
+
+	pool := NewRelayPool(stuck, cheating, good).WithLogger(NewLogger(LevelError, io.Discard))
+	result, err := pool.RaceProxy(context.Background(), invoice, 0, RaceOptions{Mode: RaceFirst, LatencyBudget: 5 * time.Second})
+	if err != nil {
+		t.Fatalf("RaceProxy failed: %v", err)
+	}
+	if result.Relay != good {
+		t.Errorf("Expected the only valid relay to win, got %s", result.Relay.URL.String())
+	}
+	if len(result.Skipped) != 2 || !errors.Is(result.Skipped[0].Err, RelayNotChosen) {
+		t.Fatalf("Expected the stuck relay to be skipped as not chosen, got %+v", result.Skipped)
+	}
+	select {
+	case <-cancelled:
+	case <-time.After(5 * time.Second):
+		t.Error("Request to the stuck relay was not cancelled")
+	}
+
+	// Nothing valid within the latency budget
+	pool = NewRelayPool(stuck, cheating).WithLogger(NewLogger(LevelError, io.Discard))
+	start := time.Now()
+	_, err = pool.RaceProxy(context.Background(), invoice, 0, RaceOptions{Mode: RaceFirst, LatencyBudget: 500 * time.Millisecond})
+	var timeoutErr *TimeoutError
+	if !errors.Is(err, NoRelayAvailable) || !errors.As(err, &timeoutErr) || timeoutErr.Limit != 500*time.Millisecond {
+		t.Errorf("Expected PoolError with a TimeoutError, got %v", err)
+	}
+	if !errors.Is(err, PaymentHashMismatch) {
+		t.Errorf("Expected the cheating relay's error in the pool error, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Race took %s despite the latency budget", elapsed)
+	}
+}
@@ -0,0 +1,125 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var NoRelayAvailable = errors.New("no relay could wrap the invoice")
+
+// RelayPool wraps invoices through several lnproxy relays. Each relay is an
+// LNProxy client with its own URL and fee policy; the pool tries them in
+// slice order and fails over to the next one when a relay cannot be
+// reached, answers with an error or returns an invalid proxy invoice.
+// Relays whose circuit breaker is open fail straight away and are skipped.
+type RelayPool struct {
+	Relays []*LNProxy
+	logger *Logger
+}
+
+// NewRelayPool creates a pool trying relays in the given priority order
+func NewRelayPool(relays ...*LNProxy) *RelayPool {
+	return &RelayPool{
+		Relays: relays,
+		logger: DefaultLogger().WithComponent("RelayPool"),
+	}
+}
+
+// WithLogger sets a custom logger for the RelayPool
+func (p *RelayPool) WithLogger(logger *Logger) *RelayPool {
+	p.logger = logger.WithComponent("RelayPool")
+	return p
+}
+
+// RelayAttempt records a relay that did not serve a request and why
+type RelayAttempt struct {
+	Relay *LNProxy
+	Err   error
+}
+
+// PoolResult describes a proxy invoice obtained through a RelayPool
+type PoolResult struct {
+	ProxyInvoice string
+	// Relay is the relay that served the request.
+	Relay *LNProxy
+	// Skipped lists the relays tried before Relay, in order. After a race it
+	// lists every other relay in the pool.
+	Skipped []RelayAttempt
+}
+
+// PoolError is returned when no relay in a pool could wrap an invoice. It
+// matches NoRelayAvailable and the error of every attempt with errors.Is.
+type PoolError struct {
+	Attempts []RelayAttempt
+}
+
+func (e *PoolError) Error() string {
+	var b strings.Builder
+	b.WriteString(NoRelayAvailable.Error())
+	for _, a := range e.Attempts {
+		fmt.Fprintf(&b, "; %s: %v", a.Relay.URL.String(), a.Err)
+	}
+	return b.String()
+}
+
+func (e *PoolError) Unwrap() []error {
+	errs := []error{NoRelayAvailable}
+	for _, a := range e.Attempts {
+		errs = append(errs, a.Err)
+	}
+	return errs
+}
+
+// RequestProxy is RequestProxyContext with a background context
+func (p *RelayPool) RequestProxy(invoice string, routing_msat uint64) (*PoolResult, error) {
+	return p.RequestProxyContext(context.Background(), invoice, routing_msat)
+}
+
+// RequestProxyContext is RequestProxyWithOptions with default options
+func (p *RelayPool) RequestProxyContext(ctx context.Context, invoice string, routing_msat uint64) (*PoolResult, error) {
+	return p.RequestProxyWithOptions(ctx, invoice, routing_msat, RequestOptions{})
+}
+
+// RequestProxyWithOptions asks each relay in turn to wrap invoice with the
+// description opts asks for, validating every proxy invoice with the relay's
+// ValidateProxyInvoiceWithOptions, and returns the first valid one. A
+// routing_msat of zero lets each relay apply its own fee policy. Cancelling
+// ctx stops the pool without trying further relays.
+func (p *RelayPool) RequestProxyWithOptions(ctx context.Context, invoice string, routing_msat uint64, opts RequestOptions) (*PoolResult, error) {
+	var attempts []RelayAttempt
+	for _, relay := range p.Relays {
+		if err := ctx.Err(); err != nil {
+			p.logger.Warn("Giving up after %d relays: %v", len(attempts), err)
+			return nil, err
+		}
+
+		p.logger.Debug("Trying relay %s", relay.URL.String())
+		proxy_invoice, err := requestValidProxy(ctx, relay, invoice, routing_msat, opts)
+		if err != nil {
+			p.logger.Warn("Skipping relay %s: %v", relay.URL.String(), err)
+			attempts = append(attempts, RelayAttempt{Relay: relay, Err: err})
+			continue
+		}
+
+		p.logger.Info("Relay %s served the request after %d skipped", relay.URL.String(), len(attempts))
+		return &PoolResult{ProxyInvoice: proxy_invoice, Relay: relay, Skipped: attempts}, nil
+	}
+
+	p.logger.Error("No relay could wrap the invoice (%d tried)", len(attempts))
+	return nil, &PoolError{Attempts: attempts}
+}
+
+// requestValidProxy requests a proxy invoice from relay and validates it
+func requestValidProxy(ctx context.Context, relay *LNProxy, invoice string, routing_msat uint64, opts RequestOptions) (string, error) {
+	proxy_invoice, sample, err := relay.requestProxy(ctx, invoice, routing_msat, opts)
+	if err != nil {
+		return "", err
+	}
+	if _, err := relay.ValidateProxyInvoiceWithOptions(invoice, proxy_invoice, routing_msat, opts); err != nil {
+		relay.health.validationFailed(sample, err)
+		return "", err
+	}
+	return proxy_invoice, nil
+}
@@ -0,0 +1,123 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+// newTestRelay starts a relay that wraps invoices with the requested routing
+// budget, passing each proxy invoice through tamper before signing it. It
+// returns a quiet client for the relay; the server is closed with the test.
+func newTestRelay(t *testing.T, tamper func(*Invoice)) *LNProxy {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Invoice     string `json:"invoice"`
+			RoutingMsat uint64 `json:"routing_msat,string"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		original, err := DecodeInvoice([]byte(req.Invoice))
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"status": "ERROR", "reason": err.Error()})
+			return
+		}
+		proxy := testProxyInvoice(original, req.RoutingMsat)
+		tamper(proxy)
+		proxy_invoice, err := EncodeInvoice(proxy, relayKey)
+		if err != nil {
+			t.Errorf("EncodeInvoice failed: %v", err)
+		}
+		json.NewEncoder(w).Encode(map[string]string{"proxy_invoice": proxy_invoice})
+	}))
+	t.Cleanup(server.Close)
+	return newTestClient(server.URL)
+}
+
+// newTestClient returns a quiet client for the relay at rawURL
+func newTestClient(rawURL string) *LNProxy {
+	relayURL, _ := url.Parse(rawURL)
+	return NewLNProxy(*relayURL, 1000, 500).WithLogger(NewLogger(LevelError, io.Discard)).WithClock(testNow)
+}
+
+// newFailingRelay starts a relay answering every request with status and reason
+func newFailingRelay(t *testing.T, status int, reason string) *LNProxy {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(map[string]string{"status": "ERROR", "reason": reason})
+	}))
+	t.Cleanup(server.Close)
+	return newTestClient(server.URL)
+}
+
+func honest(*Invoice) {}
+
+func TestRelayPoolFailover(t *testing.T) {
+	invoice := mustEncode(t, testInvoice(), testKey)
+
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+	unreachable := newTestClient(down.URL)
+	failing := newFailingRelay(t, http.StatusInternalServerError, "internal error")
+	cheating := newTestRelay(t, func(p *Invoice) { p.AmountMsat += 10_000 })
+	good := newTestRelay(t, honest)
+
+	pool := NewRelayPool(unreachable, failing, cheating, good).WithLogger(NewLogger(LevelError, io.Discard))
+	result, err := pool.RequestProxy(invoice, 0)
+	if err != nil {
+		t.Fatalf("RequestProxy failed: %v", err)
+	}
+	if result.Relay != good {
+		t.Errorf("Expected the last relay to serve the request, got %s", result.Relay.URL.String())
+	}
+	if ok, err := good.ValidateProxyInvoice(invoice, result.ProxyInvoice, 0); !ok {
+		t.Errorf("Pool returned an invalid proxy invoice: %v", err)
+	}
+	if len(result.Skipped) != 3 {
+		t.Fatalf("Expected 3 skipped relays, got %d", len(result.Skipped))
+	}
+	for i, want := range []*LNProxy{unreachable, failing, cheating} {
+		if result.Skipped[i].Relay != want {
+			t.Errorf("Skipped relay %d is %s, expected %s", i, result.Skipped[i].Relay.URL.String(), want.URL.String())
+		}
+	}
+	if !errors.Is(result.Skipped[1].Err, LNProxyError) {
+		t.Errorf("Expected LNProxyError for the failing relay, got %v", result.Skipped[1].Err)
+	}
+	if !errors.Is(result.Skipped[2].Err, CustomRoutingBudgetMismatch) {
+		t.Errorf("Expected CustomRoutingBudgetMismatch for the cheating relay, got %v", result.Skipped[2].Err)
+	}
+
+	// The first healthy relay is preferred
+	pool = NewRelayPool(good, cheating).WithLogger(NewLogger(LevelError, io.Discard))
+	result, err = pool.RequestProxy(invoice, 0)
+	if err != nil || result.Relay != good || len(result.Skipped) != 0 {
+		t.Errorf("Expected first relay to serve the request directly, got %+v, %v", result, err)
+	}
+}
+
+func TestRelayPoolExhausted(t *testing.T) {
+	invoice := mustEncode(t, testInvoice(), testKey)
+	pool := NewRelayPool(
+		newFailingRelay(t, http.StatusBadRequest, "invoice expired"),
+		newTestRelay(t, func(p *Invoice) { p.PaymentHash = make([]byte, 32) }),
+	).WithLogger(NewLogger(LevelError, io.Discard))
+
+	result, err := pool.RequestProxy(invoice, 0)
+	if result != nil {
+		t.Errorf("Expected no result, got %+v", result)
+	}
+	var poolErr *PoolError
+	if !errors.As(err, &poolErr) || len(poolErr.Attempts) != 2 {
+		t.Fatalf("Expected PoolError with 2 attempts, got %v", err)
+	}
+	if !errors.Is(err, NoRelayAvailable) || !errors.Is(err, LNProxyError) || !errors.Is(err, PaymentHashMismatch) {
+		t.Errorf("Expected pool error to match every attempt, got %v", err)
+	}
+}
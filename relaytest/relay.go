@@ -0,0 +1,280 @@
+// Package relaytest provides an lnproxy relay for tests and local
+// development. It speaks the JSON API LNProxy.RequestProxy uses and issues
+// real signed proxy invoices, without a Lightning node behind them, and it
+// can be told to misbehave so that every validation failure can be
+// exercised end-to-end.
+package relaytest
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/decred/dcrd/dcrec/secp256k1/v4"
+	client "github.com/lnproxy/lnproxy-client"
+)
+
+// Faults switches on ways for the relay to misbehave. The zero value is an
+// honest relay.
+type Faults struct {
+	// WrongHash replaces the payment hash of the proxy invoice.
+	WrongHash bool
+	// AlterDescription changes the description, or the description hash,
+	// of the proxy invoice.
+	AlterDescription bool
+	// SkimMsat is added to the proxy amount on top of the routing budget.
+	// Amountless invoices get a proxy invoice for SkimMsat instead of an
+	// amountless one.
+	SkimMsat uint64
+	// ReuseSignature hands back the original invoice, signature and all,
+	// so that the payee is not hidden.
+	ReuseSignature bool
+	// ExtendExpiry makes the proxy invoice expire this long after the
+	// original.
+	ExtendExpiry time.Duration
+	// ReusePaymentSecret copies the original's payment secret.
+	ReusePaymentSecret bool
+	// RefuseZeroAmount answers amountless invoices with an error, as relays
+	// that cannot wrap them do.
+	RefuseZeroAmount bool
+	// FailStatus, when set, answers every request with this HTTP status
+	// and FailReason.
+	FailStatus int
+	FailReason string
+}
+
+// Request is a wrap request received by the relay
+type Request struct {
+	Invoice string
+	// RoutingMsat is zero when RelayChosen is set.
+	RoutingMsat     uint64
+	RelayChosen     bool
+	Description     string
+	DescriptionHash []byte
+}
+
+// Relay is an http.Handler serving the lnproxy relay API. Its fields must
+// not be changed while it serves requests; use SetFaults to switch faults on
+// and off.
+type Relay struct {
+	// Key signs the proxy invoices.
+	Key *secp256k1.PrivateKey
+	// BaseMsat and Ppm make up the routing budget of requests that leave
+	// it to the relay.
+	BaseMsat uint64
+	Ppm      uint64
+	// CltvDelta is added to the original min_final_cltv_expiry.
+	CltvDelta uint64
+	// ExpiryMargin is how long before the original the proxy invoice
+	// expires. Invoices with less lifetime left are refused.
+	ExpiryMargin time.Duration
+	// Spec, when set, is served at GET /spec.
+	Spec *client.RelaySpec
+	// Now is the relay's clock.
+	Now func() time.Time
+
+	mu       sync.Mutex
+	faults   Faults
+	requests []Request
+}
+
+// NewRelay returns an honest relay with a random key
+func NewRelay() *Relay {
+	key, err := secp256k1.GeneratePrivateKey()
+	if err != nil {
+		panic(fmt.Sprintf("relaytest: cannot generate key: %v", err))
+	}
+	return &Relay{
+		Key:          key,
+		BaseMsat:     1000,
+		Ppm:          1000,
+		CltvDelta:    40,
+		ExpiryMargin: time.Minute,
+		Now:          time.Now,
+	}
+}
+
+// NewServer starts an httptest server for a new relay. The caller closes
+// the server when done.
+func NewServer() (*Relay, *httptest.Server) {
+	relay := NewRelay()
+	return relay, httptest.NewServer(relay)
+}
+
+// SetFaults replaces the relay's faults
+func (r *Relay) SetFaults(faults Faults) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.faults = faults
+}
+
+// Requests returns the wrap requests received so far
+func (r *Relay) Requests() []Request {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]Request(nil), r.requests...)
+}
+
+// PubKey returns the relay's node ID, the payee of its proxy invoices
+func (r *Relay) PubKey() []byte {
+	return r.Key.PubKey().SerializeCompressed()
+}
+
+func (r *Relay) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method == "GET" && strings.HasSuffix(req.URL.Path, "/spec") {
+		if r.Spec == nil {
+			http.NotFound(w, req)
+			return
+		}
+		writeJSON(w, http.StatusOK, r.Spec)
+		return
+	}
+	if req.Method != "POST" {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	var body struct {
+		Invoice         string `json:"invoice"`
+		RoutingMsat     string `json:"routing_msat"`
+		Description     string `json:"description"`
+		DescriptionHash string `json:"description_hash"`
+	}
+	if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	request := Request{Invoice: body.Invoice, Description: body.Description}
+	if body.RoutingMsat == "" {
+		request.RelayChosen = true
+	} else {
+		routingMsat, err := strconv.ParseUint(body.RoutingMsat, 10, 64)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid routing_msat")
+			return
+		}
+		request.RoutingMsat = routingMsat
+	}
+	if body.DescriptionHash != "" {
+		hash, err := hex.DecodeString(body.DescriptionHash)
+		if err != nil || len(hash) != 32 {
+			writeError(w, http.StatusBadRequest, "invalid description_hash")
+			return
+		}
+		request.DescriptionHash = hash
+	}
+
+	r.mu.Lock()
+	faults := r.faults
+	r.requests = append(r.requests, request)
+	r.mu.Unlock()
+
+	if faults.FailStatus != 0 {
+		writeError(w, faults.FailStatus, faults.FailReason)
+		return
+	}
+	proxy_invoice, status, err := r.wrap(request, faults)
+	if err != nil {
+		writeError(w, status, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"proxy_invoice": proxy_invoice})
+}
+
+// wrap issues the proxy invoice for request, returning the status to fail
+// with when the relay refuses it
+func (r *Relay) wrap(request Request, faults Faults) (string, int, error) {
+	original, err := client.DecodeInvoice([]byte(request.Invoice))
+	if err != nil {
+		return "", http.StatusBadRequest, fmt.Errorf("invalid invoice: %v", err)
+	}
+	if original.AmountMsat == 0 && faults.RefuseZeroAmount {
+		return "", http.StatusBadRequest, fmt.Errorf("zero amount invoices are not supported")
+	}
+	now := r.Now().Truncate(time.Second)
+	lifetime := (original.Timestamp.Add(original.Expiry).Sub(now) - r.ExpiryMargin).Truncate(time.Second)
+	if lifetime <= 0 {
+		return "", http.StatusBadRequest, fmt.Errorf("invoice expired or expires too soon")
+	}
+	if faults.ReuseSignature {
+		return request.Invoice, 0, nil
+	}
+
+	expiry := lifetime
+	if faults.ExtendExpiry > 0 {
+		expiry += r.ExpiryMargin + faults.ExtendExpiry
+	}
+	// An amountless invoice is wrapped in an amountless proxy invoice, and
+	// the routing budget comes out of whatever the payer sends.
+	var amountMsat uint64
+	if original.AmountMsat > 0 {
+		routingMsat := request.RoutingMsat
+		if request.RelayChosen {
+			routingMsat = r.BaseMsat + original.AmountMsat/1_000_000*r.Ppm + original.AmountMsat%1_000_000*r.Ppm/1_000_000
+		}
+		amountMsat = original.AmountMsat + routingMsat
+	}
+	proxy := &client.Invoice{
+		Network:            original.Network,
+		AmountMsat:         amountMsat + faults.SkimMsat,
+		Timestamp:          now,
+		PaymentHash:        original.PaymentHash,
+		PaymentSecret:      randomBytes(32),
+		Description:        original.Description,
+		DescriptionHash:    original.DescriptionHash,
+		Expiry:             expiry,
+		MinFinalCLTVExpiry: original.MinFinalCLTVExpiry + r.CltvDelta,
+		Features:           original.Features,
+	}
+	switch {
+	case request.DescriptionHash != nil:
+		proxy.Description, proxy.DescriptionHash = "", request.DescriptionHash
+	case request.Description != "":
+		proxy.Description, proxy.DescriptionHash = request.Description, nil
+	}
+
+	if faults.WrongHash {
+		proxy.PaymentHash = randomBytes(32)
+	}
+	if faults.AlterDescription {
+		if proxy.DescriptionHash != nil {
+			proxy.DescriptionHash = randomBytes(32)
+		} else {
+			proxy.Description += " (altered)"
+		}
+	}
+	if faults.ReusePaymentSecret {
+		proxy.PaymentSecret = original.PaymentSecret
+	}
+
+	proxy_invoice, err := client.EncodeInvoice(proxy, r.Key)
+	if err != nil {
+		return "", http.StatusInternalServerError, fmt.Errorf("cannot encode proxy invoice: %v", err)
+	}
+	return proxy_invoice, 0, nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, reason string) {
+	writeJSON(w, status, map[string]string{"status": "ERROR", "reason": reason})
+}
+
+func randomBytes(n int) []byte {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		panic(fmt.Sprintf("relaytest: cannot read random bytes: %v", err))
+	}
+	return b
+}
@@ -0,0 +1,197 @@
+package relaytest
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/decred/dcrd/dcrec/secp256k1/v4"
+	client "github.com/lnproxy/lnproxy-client"
+)
+
+var (
+	payeeKey = secp256k1.PrivKeyFromBytes(bytes.Repeat([]byte{0x11}, 32))
+	now      = time.Unix(1700000000, 0)
+)
+
+// testInvoice returns a 250000 sat invoice issued a minute before now
+func testInvoice(t *testing.T, expiry time.Duration) string {
+	t.Helper()
+	invoice, err := client.EncodeInvoice(&client.Invoice{
+		AmountMsat:    250_000_000,
+		Timestamp:     now.Add(-time.Minute),
+		PaymentHash:   bytes.Repeat([]byte{0x01}, 32),
+		PaymentSecret: bytes.Repeat([]byte{0x02}, 32),
+		Description:   "1 cup coffee",
+		Expiry:        expiry,
+		Features:      client.FeatureVector{8, 14},
+	}, payeeKey)
+	if err != nil {
+		t.Fatalf("EncodeInvoice failed: %v", err)
+	}
+	return invoice
+}
+
+// newTestServer starts a relay with a fixed clock and returns a quiet client
+// for it
+func newTestServer(t *testing.T) (*Relay, *client.LNProxy) {
+	t.Helper()
+	relay, server := NewServer()
+	relay.Now = func() time.Time { return now }
+	t.Cleanup(server.Close)
+	serverURL, _ := url.Parse(server.URL)
+	x := client.NewLNProxy(*serverURL, 0, 0).WithLogger(client.NewLogger(client.LevelError, io.Discard))
+	x.Now = relay.Now
+	return relay, x
+}
+
+func TestRelayHonest(t *testing.T) {
+	client.SetGlobalOutput(io.Discard)
+	relay, x := newTestServer(t)
+	invoice := testInvoice(t, time.Hour)
+
+	proxyInvoice, err := x.RequestProxy(invoice, 5000)
+	if err != nil {
+		t.Fatalf("RequestProxy failed: %v", err)
+	}
+	report := x.AuditProxyInvoice(invoice, proxyInvoice, 5000)
+	if !report.OK() {
+		t.Fatalf("Expected honest proxy invoice to validate, got %v", report.Err())
+	}
+	if !bytes.Equal(report.Proxy.Payee, relay.PubKey()) {
+		t.Errorf("Expected proxy invoice signed by the relay")
+	}
+	if want := now.Add(59 * time.Minute).Add(-relay.ExpiryMargin); !report.Proxy.Timestamp.Add(report.Proxy.Expiry).Equal(want) {
+		t.Errorf("Expected proxy invoice to expire at %s, got %s", want, report.Proxy.Timestamp.Add(report.Proxy.Expiry))
+	}
+
+	// Budget chosen by the relay
+	x.RelayChosenBudget = true
+	x.MaxBudgetMsat = 1_000_000
+	proxyInvoice, err = x.RequestProxy(invoice, 0)
+	if err != nil {
+		t.Fatalf("RequestProxy failed: %v", err)
+	}
+	if budget, _ := client.ImpliedRoutingBudget(invoice, proxyInvoice); budget != 1000+250_000 {
+		t.Errorf("Expected relay-chosen budget of 251000 msat, got %d", budget)
+	}
+
+	// Description hash override
+	hash := bytes.Repeat([]byte{0xab}, 32)
+	opts := client.RequestOptions{DescriptionHash: hash}
+	proxyInvoice, err = x.RequestProxyWithOptions(context.Background(), invoice, 5000, opts)
+	if err != nil {
+		t.Fatalf("RequestProxyWithOptions failed: %v", err)
+	}
+	if ok, err := x.ValidateProxyInvoiceWithOptions(invoice, proxyInvoice, 5000, opts); !ok {
+		t.Errorf("Expected description hash override to validate, got %v", err)
+	}
+
+	requests := relay.Requests()
+	if len(requests) != 3 || requests[0].RoutingMsat != 5000 || !requests[1].RelayChosen || !bytes.Equal(requests[2].DescriptionHash, hash) {
+		t.Errorf("Unexpected recorded requests %+v", requests)
+	}
+}
+
+func TestRelayFaults(t *testing.T) {
+	client.SetGlobalOutput(io.Discard)
+	invoice := testInvoice(t, time.Hour)
+	tests := []struct {
+		name   string
+		faults Faults
+		check  string
+		err    error
+	}{
+		{"wrong hash", Faults{WrongHash: true}, client.CheckPaymentHash, client.PaymentHashMismatch},
+		{"altered description", Faults{AlterDescription: true}, client.CheckDescription, client.DescriptionMismatch},
+		{"skimmed budget", Faults{SkimMsat: 1}, client.CheckAmount, client.CustomRoutingBudgetMismatch},
+		{"reused signature", Faults{ReuseSignature: true}, client.CheckPayee, client.DestinationNotProxied},
+		{"extended expiry", Faults{ExtendExpiry: time.Hour}, client.CheckExpiry, client.ExpiryExceedsOriginal},
+		{"reused payment secret", Faults{ReusePaymentSecret: true}, client.CheckPaymentSecret, client.InvalidPaymentSecret},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			relay, x := newTestServer(t)
+			relay.SetFaults(tt.faults)
+			proxyInvoice, err := x.RequestProxy(invoice, 5000)
+			if err != nil {
+				t.Fatalf("RequestProxy failed: %v", err)
+			}
+			report := x.AuditProxyInvoice(invoice, proxyInvoice, 5000)
+			check, _ := report.Check(tt.check)
+			if check.Status != client.CheckFail || !errors.Is(check.Err, tt.err) {
+				t.Errorf("Expected %s check to fail with %v, got %+v", tt.check, tt.err, check)
+			}
+			if ok, _ := x.ValidateProxyInvoice(invoice, proxyInvoice, 5000); ok {
+				t.Error("Expected ValidateProxyInvoice to reject the proxy invoice")
+			}
+		})
+	}
+}
+
+func TestRelayZeroAmount(t *testing.T) {
+	client.SetGlobalOutput(io.Discard)
+	relay, x := newTestServer(t)
+	invoice, err := client.EncodeInvoice(&client.Invoice{
+		Timestamp:     now.Add(-time.Minute),
+		PaymentHash:   bytes.Repeat([]byte{0x01}, 32),
+		PaymentSecret: bytes.Repeat([]byte{0x02}, 32),
+		Description:   "tip jar",
+		Expiry:        time.Hour,
+	}, payeeKey)
+	if err != nil {
+		t.Fatalf("EncodeInvoice failed: %v", err)
+	}
+
+	x.WithZeroAmount(100_000_000)
+	proxyInvoice, err := x.RequestProxy(invoice, 5000)
+	if err != nil {
+		t.Fatalf("RequestProxy failed: %v", err)
+	}
+	report := x.AuditProxyInvoice(invoice, proxyInvoice, 5000)
+	if !report.OK() || report.Proxy.AmountMsat != 0 {
+		t.Fatalf("Expected a valid amountless proxy invoice, got %d msat, %v", report.Proxy.AmountMsat, report.Err())
+	}
+
+	// A proxy invoice with an amount is not how amountless invoices are
+	// wrapped
+	relay.SetFaults(Faults{SkimMsat: 5000})
+	proxyInvoice, err = x.RequestProxy(invoice, 5000)
+	if err != nil {
+		t.Fatalf("RequestProxy failed: %v", err)
+	}
+	if ok, err := x.ValidateProxyInvoice(invoice, proxyInvoice, 5000); ok || !errors.Is(err, client.ZeroAmountMismatch) {
+		t.Errorf("Expected ZeroAmountMismatch, got %v", err)
+	}
+
+	relay.SetFaults(Faults{RefuseZeroAmount: true})
+	var relayErr *client.RelayError
+	if _, err := x.RequestProxy(invoice, 5000); !errors.As(err, &relayErr) || relayErr.HTTPStatus != http.StatusBadRequest {
+		t.Errorf("Expected a 400 RelayError for the amountless invoice, got %v", err)
+	}
+}
+
+func TestRelayErrors(t *testing.T) {
+	client.SetGlobalOutput(io.Discard)
+	relay, x := newTestServer(t)
+
+	relay.SetFaults(Faults{FailStatus: http.StatusServiceUnavailable, FailReason: "node offline"})
+	_, err := x.RequestProxy(testInvoice(t, time.Hour), 5000)
+	var relayErr *client.RelayError
+	if !errors.As(err, &relayErr) || relayErr.HTTPStatus != http.StatusServiceUnavailable || relayErr.Reason != "node offline" || relayErr.Status != "ERROR" {
+		t.Errorf("Expected RelayError from the failing relay, got %v", err)
+	}
+
+	relay.SetFaults(Faults{})
+	if _, err := x.RequestProxy(testInvoice(t, 90*time.Second), 5000); !errors.Is(err, client.InvoiceExpired) || !errors.As(err, &relayErr) {
+		t.Errorf("Expected InvoiceExpired from the relay for an invoice about to expire, got %v", err)
+	}
+	if _, err := x.RequestProxy("lnbc1invalid", 5000); !errors.As(err, &relayErr) || relayErr.HTTPStatus != http.StatusBadRequest {
+		t.Errorf("Expected a 400 RelayError for an invalid invoice, got %v", err)
+	}
+}
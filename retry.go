@@ -0,0 +1,165 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math"
+	"math/rand"
+	"net"
+	"net/http"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// RetryPolicy configures how LNProxy retries requests that fail for
+// transient reasons. The zero value makes a single attempt.
+type RetryPolicy struct {
+	// MaxAttempts is the number of requests made at most, the first one
+	// included.
+	MaxAttempts int
+	// InitialBackoff is the wait before the first retry. It doubles after
+	// every further attempt, up to MaxBackoff when that is set.
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	// Jitter is the share of each wait, between 0 and 1, that is randomly
+	// taken off so that clients do not retry in lockstep.
+	Jitter float64
+	// RetryStatus lists the HTTP status codes worth retrying. When nil,
+	// DefaultRetryStatus is used.
+	RetryStatus []int
+	// RetryError decides whether an error other than a *RelayError is
+	// worth retrying. When nil, failed dials, refused or dropped
+	// connections and the client's own RequestTimeout or Client.Timeout
+	// are retried.
+	RetryError func(error) bool
+	// NonRetryableReasons lists relay reasons that are never retried,
+	// whatever the status, matched case-insensitively as substrings. When
+	// nil, DefaultNonRetryableReasons is used. Relay errors classed as
+	// AmountTooSmall, InvoiceExpired or DescriptionHashUnsupported are never
+	// retried either.
+	NonRetryableReasons []string
+}
+
+// DefaultRetryPolicy is a retry policy suited to most relays
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts:    3,
+	InitialBackoff: 500 * time.Millisecond,
+	MaxBackoff:     5 * time.Second,
+	Jitter:         0.2,
+}
+
+// DefaultRetryStatus lists the status codes of relays that are overloaded,
+// restarting or failing to reach their node
+var DefaultRetryStatus = []int{
+	http.StatusTooManyRequests,
+	http.StatusInternalServerError,
+	http.StatusBadGateway,
+	http.StatusServiceUnavailable,
+	http.StatusGatewayTimeout,
+}
+
+// DefaultNonRetryableReasons lists reasons relays give when they refuse an
+// invoice by policy; asking again gets the same answer. They are specific
+// enough not to catch transient failures such as "invalid response from
+// node".
+var DefaultNonRetryableReasons = []string{
+	"invalid invoice",
+	"invalid payment request",
+	"invalid routing_msat",
+	"routing budget",
+}
+
+// WithRetryPolicy sets the retry policy of the LNProxy client
+func (x *LNProxy) WithRetryPolicy(policy RetryPolicy) *LNProxy {
+	x.Retry = policy
+	return x
+}
+
+// attempts returns the number of requests the policy allows
+func (p RetryPolicy) attempts() int {
+	if p.MaxAttempts < 1 {
+		return 1
+	}
+	return p.MaxAttempts
+}
+
+// retryable reports whether a request that failed with err is worth retrying
+func (p RetryPolicy) retryable(err error) bool {
+	if errors.Is(err, context.Canceled) {
+		return false
+	}
+	var relayErr *RelayError
+	if errors.As(err, &relayErr) {
+		if errors.Is(err, AmountTooSmall) || errors.Is(err, InvoiceExpired) || errors.Is(err, DescriptionHashUnsupported) {
+			return false
+		}
+		reasons := p.NonRetryableReasons
+		if reasons == nil {
+			reasons = DefaultNonRetryableReasons
+		}
+		for _, r := range reasons {
+			if strings.Contains(strings.ToLower(relayErr.Reason), strings.ToLower(r)) {
+				return false
+			}
+		}
+		statuses := p.RetryStatus
+		if statuses == nil {
+			statuses = DefaultRetryStatus
+		}
+		for _, s := range statuses {
+			if s == relayErr.HTTPStatus {
+				return true
+			}
+		}
+		return false
+	}
+	if p.RetryError != nil {
+		return p.RetryError(err)
+	}
+	var timeoutErr *TimeoutError
+	if errors.As(err, &timeoutErr) {
+		// The caller's deadline has passed, another attempt cannot make it
+		return timeoutErr.Limit > 0
+	}
+	return transientNetError(err)
+}
+
+// transientNetError reports whether err is a network failure that may not
+// happen again: a timeout, a failed dial, or a connection refused, reset or
+// closed before the response. Errors such as a certificate the client does
+// not trust or an unsupported URL scheme are not transient, although the
+// *url.Error they come in is a net.Error.
+func transientNetError(err error) bool {
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return dnsErr.IsTemporary
+	}
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && opErr.Op == "dial" {
+		return true
+	}
+	return errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNABORTED) || errors.Is(err, syscall.EPIPE) ||
+		errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// backoff returns the wait after the given attempt, counting from 1
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	wait := p.InitialBackoff
+	for i := 1; i < attempt && wait < math.MaxInt64/2 && (p.MaxBackoff == 0 || wait < p.MaxBackoff); i++ {
+		wait *= 2
+	}
+	if p.MaxBackoff > 0 && wait > p.MaxBackoff {
+		wait = p.MaxBackoff
+	}
+	if p.Jitter > 0 {
+		wait -= time.Duration(rand.Float64() * p.Jitter * float64(wait))
+	}
+	return wait
+}
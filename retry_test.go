@@ -0,0 +1,182 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newFlakyRelay starts a relay answering the first failures requests with
+// status and reason, and the ones after with a proxy invoice. It returns the
+// relay's URL and a pointer to the number of requests served.
+func newFlakyRelay(t *testing.T, failures, status int, reason string) (string, *int) {
+	t.Helper()
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if requests <= failures {
+			w.WriteHeader(status)
+			json.NewEncoder(w).Encode(map[string]string{"status": "ERROR", "reason": reason})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"proxy_invoice": "proxy-test-invoice"})
+	}))
+	t.Cleanup(server.Close)
+	return server.URL, &requests
+}
+
+func TestRequestProxyRetry(t *testing.T) {
+	relayURL, requests := newFlakyRelay(t, 2, http.StatusServiceUnavailable, "node offline")
+	var logBuffer bytes.Buffer
+	x := newTestClient(relayURL).WithLogger(NewLogger(LevelDebug, &logBuffer)).
+		WithRetryPolicy(RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond})
+
+	proxyInvoice, err := x.RequestProxy("test-invoice", 1500)
+	if err != nil || proxyInvoice != "proxy-test-invoice" {
+		t.Fatalf("Expected success on the third attempt, got %q, %v", proxyInvoice, err)
+	}
+	if *requests != 3 {
+		t.Errorf("Expected 3 requests, got %d", *requests)
+	}
+	for _, want := range []string{"attempt 1/3", "attempt 2/3", "attempt 3/3", "Attempt 1/3 to " + relayURL + " failed, retrying"} {
+		if !strings.Contains(logBuffer.String(), want) {
+			t.Errorf("Expected log to contain %q", want)
+		}
+	}
+
+	// A transient failure mentioning something invalid is still retried
+	relayURL, requests = newFlakyRelay(t, 1, http.StatusBadGateway, "invalid response from node")
+	x = newTestClient(relayURL).WithRetryPolicy(RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond})
+	if _, err := x.RequestProxy("test-invoice", 1500); err != nil || *requests != 2 {
+		t.Errorf("Expected success on the second attempt, got %v after %d requests", err, *requests)
+	}
+
+	// Out of attempts
+	relayURL, requests = newFlakyRelay(t, 5, http.StatusBadGateway, "node offline")
+	x = newTestClient(relayURL).WithRetryPolicy(RetryPolicy{MaxAttempts: 2, InitialBackoff: time.Millisecond})
+	if _, err := x.RequestProxy("test-invoice", 1500); !errors.Is(err, LNProxyError) || *requests != 2 {
+		t.Errorf("Expected LNProxyError after 2 requests, got %v after %d", err, *requests)
+	}
+}
+
+func TestRequestProxyNoRetry(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		reason string
+		policy RetryPolicy
+	}{
+		{"client error", http.StatusBadRequest, "bad request", RetryPolicy{}},
+		{"policy reason", http.StatusServiceUnavailable, "Invoice expired", RetryPolicy{}},
+		{"default reason", http.StatusServiceUnavailable, "Invalid invoice: bad checksum", RetryPolicy{}},
+		{"custom reason", http.StatusServiceUnavailable, "relay paused", RetryPolicy{NonRetryableReasons: []string{"paused"}}},
+		{"custom status", http.StatusServiceUnavailable, "node offline", RetryPolicy{RetryStatus: []int{http.StatusTooManyRequests}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			relayURL, requests := newFlakyRelay(t, 1, tt.status, tt.reason)
+			tt.policy.MaxAttempts = 3
+			x := newTestClient(relayURL).WithRetryPolicy(tt.policy)
+			if _, err := x.RequestProxy("test-invoice", 1500); !errors.Is(err, LNProxyError) {
+				t.Errorf("Expected LNProxyError, got %v", err)
+			}
+			if *requests != 1 {
+				t.Errorf("Expected a single request, got %d", *requests)
+			}
+		})
+	}
+}
+
+func TestRequestProxyRetryTransport(t *testing.T) {
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+
+	attempts := 0
+	x := newTestClient(down.URL).WithRetryPolicy(RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond})
+	x.Retry.RetryError = func(err error) bool {
+		attempts++
+		return true
+	}
+	if _, err := x.RequestProxy("test-invoice", 1500); err == nil || attempts != 2 {
+		t.Errorf("Expected 3 failed attempts, got %v after %d retries", err, attempts)
+	}
+
+	// Cancelling during the backoff stops the retries
+	x = newTestClient(down.URL).WithRetryPolicy(RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Minute})
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := x.RequestProxyContext(ctx, "test-invoice", 1500)
+	var timeoutErr *TimeoutError
+	if !errors.As(err, &timeoutErr) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected TimeoutError from the context deadline, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Backoff took %s despite the deadline", elapsed)
+	}
+}
+
+func TestRetryTransportErrors(t *testing.T) {
+	// A relay that is down is worth trying again
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+	_, err := newTestClient(down.URL).RequestProxy("test-invoice", 1500)
+	if err == nil || !DefaultRetryPolicy.retryable(err) {
+		t.Errorf("Expected a refused connection to be retried, got %v", err)
+	}
+
+	// A certificate the client does not trust stays untrusted
+	var connections int32
+	untrusted := httptest.NewUnstartedServer(http.NotFoundHandler())
+	untrusted.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			atomic.AddInt32(&connections, 1)
+		}
+	}
+	untrusted.Config.ErrorLog = log.New(io.Discard, "", 0)
+	untrusted.StartTLS()
+	defer untrusted.Close()
+	x := newTestClient(untrusted.URL).WithRetryPolicy(RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond})
+	_, err = x.RequestProxy("test-invoice", 1500)
+	if err == nil || DefaultRetryPolicy.retryable(err) {
+		t.Errorf("Expected TLS verification failure not to be retried, got %v", err)
+	}
+	if n := atomic.LoadInt32(&connections); n != 1 {
+		t.Errorf("Expected a single connection, got %d", n)
+	}
+
+	// Nor does a scheme the client cannot speak
+	_, err = newTestClient("ftp://relay.example").RequestProxy("test-invoice", 1500)
+	if err == nil || DefaultRetryPolicy.retryable(err) {
+		t.Errorf("Expected unsupported scheme not to be retried, got %v", err)
+	}
+}
+
+func TestRetryBackoff(t *testing.T) {
+	p := RetryPolicy{InitialBackoff: 100 * time.Millisecond, MaxBackoff: time.Second}
+	for attempt, want := range map[int]time.Duration{1: 100 * time.Millisecond, 2: 200 * time.Millisecond, 4: 800 * time.Millisecond, 5: time.Second, 100: time.Second} {
+		if got := p.backoff(attempt); got != want {
+			t.Errorf("backoff(%d) = %s, expected %s", attempt, got, want)
+		}
+	}
+	p.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		if got := p.backoff(2); got < 100*time.Millisecond || got > 200*time.Millisecond {
+			t.Fatalf("backoff(2) with jitter = %s, expected between 100ms and 200ms", got)
+		}
+	}
+	p.MaxBackoff = 0
+	if got := p.backoff(1000); got <= 0 {
+		t.Errorf("Unbounded backoff overflowed to %s", got)
+	}
+}
@@ -0,0 +1,55 @@
+package client
+
+import (
+	"crypto/sha256"
+	"errors"
+
+	"github.com/decred/dcrd/dcrec/secp256k1/v4"
+	"github.com/decred/dcrd/dcrec/secp256k1/v4/ecdsa"
+)
+
+// ErrInvalidSignature is returned when an invoice signature does not verify
+// against its payee.
+var ErrInvalidSignature = errors.New("invalid invoice signature")
+
+// compactSigMagicOffset is the header byte offset of a compact signature
+// for a compressed public key.
+const compactSigMagicOffset = 27 + 4
+
+// signingHash returns the hash the payee signs: the human-readable part
+// followed by the data part, without signature and checksum, as bytes.
+func signingHash(hrp, data []byte) [32]byte {
+	msg := append(append([]byte{}, hrp...), convertBits(data, 5, 8, true)...)
+	return sha256.Sum256(msg)
+}
+
+// verifySignature checks the invoice signature over hash. When the invoice
+// names its payee in an n field the signature is verified against that
+// key, otherwise the payee is recovered from the signature.
+func (inv *Invoice) verifySignature(hash [32]byte) error {
+	if inv.Payee != nil {
+		pub, err := secp256k1.ParsePubKey(inv.Payee)
+		if err != nil {
+			return ErrInvalidSignature
+		}
+		var r, s secp256k1.ModNScalar
+		if r.SetByteSlice(inv.Signature[:32]) || s.SetByteSlice(inv.Signature[32:]) || r.IsZero() || s.IsZero() {
+			return ErrInvalidSignature
+		}
+		if !ecdsa.NewSignature(&r, &s).Verify(hash[:], pub) {
+			return ErrInvalidSignature
+		}
+		return nil
+	}
+
+	if inv.RecoveryID > 3 {
+		return ErrInvalidSignature
+	}
+	compact := append([]byte{compactSigMagicOffset + inv.RecoveryID}, inv.Signature...)
+	pub, _, err := ecdsa.RecoverCompact(compact, hash[:])
+	if err != nil {
+		return ErrInvalidSignature
+	}
+	inv.Payee = pub.SerializeCompressed()
+	return nil
+}
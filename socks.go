@@ -0,0 +1,80 @@
+package client
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+var OnionRequiresProxy = errors.New("onion relay requires a SOCKS5 proxy")
+
+// SOCKSProxy configures the SOCKS5 proxy, usually a Tor daemon, through
+// which an LNProxy client reaches its relay. Host names are resolved by the
+// proxy, so onion addresses work and no DNS query leaves the machine.
+type SOCKSProxy struct {
+	// Address is the host:port of the proxy, 127.0.0.1:9050 for a local
+	// Tor daemon.
+	Address string
+	// Username and Password authenticate with the proxy (RFC 1929).
+	Username string
+	Password string
+	// IsolateStreams sends every request with fresh random credentials,
+	// which Tor takes as a request for a circuit of its own. Connections are
+	// then never reused between requests. Username and Password are ignored.
+	IsolateStreams bool
+}
+
+// proxyURL returns the proxy URL for one request
+func (p *SOCKSProxy) proxyURL(*http.Request) (*url.URL, error) {
+	u := &url.URL{Scheme: "socks5", Host: p.Address}
+	switch {
+	case p.IsolateStreams:
+		var buf [32]byte
+		if _, err := rand.Read(buf[:]); err != nil {
+			return nil, fmt.Errorf("cannot generate stream isolation credentials: %w", err)
+		}
+		u.User = url.UserPassword(hex.EncodeToString(buf[:16]), hex.EncodeToString(buf[16:]))
+	case p.Username != "":
+		u.User = url.UserPassword(p.Username, p.Password)
+	}
+	return u, nil
+}
+
+// WithSOCKS5 sends the LNProxy client's requests through a SOCKS5 proxy,
+// replacing the transport of its http.Client
+func (x *LNProxy) WithSOCKS5(proxy SOCKSProxy) *LNProxy {
+	x.Client.Transport = &http.Transport{
+		Proxy:             proxy.proxyURL,
+		DisableKeepAlives: proxy.IsolateStreams,
+	}
+	return x
+}
+
+// proxied reports whether requests to the relay leave through a SOCKS5
+// proxy, asking the transport's Proxy function as a request would. A proxy
+// function that picks no proxy, such as http.ProxyFromEnvironment with no
+// variables set, or an HTTP proxy that would see the host name, does not
+// count.
+func (x *LNProxy) proxied() bool {
+	t, ok := x.Client.Transport.(*http.Transport)
+	if !ok || t.Proxy == nil {
+		return false
+	}
+	target := x.URL
+	u, err := t.Proxy(&http.Request{Method: "POST", URL: &target, Header: http.Header{}})
+	return err == nil && u != nil && (u.Scheme == "socks5" || u.Scheme == "socks5h")
+}
+
+// checkTransport refuses to contact an onion relay without a proxy, which
+// would at best fail and at worst leak the onion address to the resolver
+func (x *LNProxy) checkTransport() error {
+	if !strings.HasSuffix(strings.ToLower(x.URL.Hostname()), ".onion") || x.proxied() {
+		return nil
+	}
+	x.logger.Error("Refusing to reach onion relay %s without a SOCKS5 proxy", x.URL.Host)
+	return fmt.Errorf("%w: %s", OnionRequiresProxy, x.URL.Host)
+}
@@ -0,0 +1,196 @@
+package client
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+// socksConn records one connection made through the SOCKS5 stand-in
+type socksConn struct {
+	Username string
+	Target   string
+}
+
+// socksServer is a minimal SOCKS5 proxy (RFC 1928, RFC 1929) that connects
+// to the address routes maps a target to, or to the target itself
+type socksServer struct {
+	net.Listener
+	routes map[string]string
+
+	mu    sync.Mutex
+	conns []socksConn
+}
+
+func newSOCKSServer(t *testing.T, routes map[string]string) *socksServer {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Cannot listen: %v", err)
+	}
+	s := &socksServer{Listener: l, routes: routes}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *socksServer) Conns() []socksConn {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]socksConn(nil), s.conns...)
+}
+
+func (s *socksServer) serve(conn net.Conn) {
+	defer conn.Close()
+	buf := make([]byte, 256)
+
+	// Greeting: pick username/password authentication when offered
+	if _, err := io.ReadFull(conn, buf[:2]); err != nil || buf[0] != 5 {
+		return
+	}
+	methods := buf[2 : 2+buf[1]]
+	if _, err := io.ReadFull(conn, methods); err != nil {
+		return
+	}
+	method := byte(0)
+	for _, m := range methods {
+		if m == 2 {
+			method = 2
+		}
+	}
+	conn.Write([]byte{5, method})
+
+	var username string
+	if method == 2 {
+		if _, err := io.ReadFull(conn, buf[:2]); err != nil {
+			return
+		}
+		user := make([]byte, buf[1])
+		io.ReadFull(conn, user)
+		io.ReadFull(conn, buf[:1])
+		io.ReadFull(conn, make([]byte, buf[0]))
+		username = string(user)
+		conn.Write([]byte{1, 0})
+	}
+
+	// CONNECT request
+	if _, err := io.ReadFull(conn, buf[:4]); err != nil || buf[1] != 1 {
+		return
+	}
+	var host string
+	switch buf[3] {
+	case 1:
+		io.ReadFull(conn, buf[:4])
+		host = net.IP(buf[:4]).String()
+	case 3:
+		io.ReadFull(conn, buf[:1])
+		name := make([]byte, buf[0])
+		io.ReadFull(conn, name)
+		host = string(name)
+	default:
+		conn.Write([]byte{5, 8, 0, 1, 0, 0, 0, 0, 0, 0})
+		return
+	}
+	io.ReadFull(conn, buf[:2])
+	target := net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(buf[:2]))))
+
+	s.mu.Lock()
+	s.conns = append(s.conns, socksConn{Username: username, Target: target})
+	s.mu.Unlock()
+
+	addr, ok := s.routes[target]
+	if !ok {
+		addr = target
+	}
+	upstream, err := net.Dial("tcp", addr)
+	if err != nil {
+		conn.Write([]byte{5, 4, 0, 1, 0, 0, 0, 0, 0, 0})
+		return
+	}
+	defer upstream.Close()
+	conn.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0})
+	go io.Copy(upstream, conn)
+	io.Copy(conn, upstream)
+}
+
+func TestRequestProxySOCKS5(t *testing.T) {
+	relay := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{"proxy_invoice": "proxy-test-invoice"})
+	}))
+	defer relay.Close()
+	const onion = "lnproxyexample7.onion:80"
+	socks := newSOCKSServer(t, map[string]string{onion: relay.Listener.Addr().String()})
+
+	x := newTestClient("http://" + onion).WithSOCKS5(SOCKSProxy{Address: socks.Addr().String(), IsolateStreams: true})
+	for i := 0; i < 2; i++ {
+		if proxyInvoice, err := x.RequestProxy("test-invoice", 1500); err != nil || proxyInvoice != "proxy-test-invoice" {
+			t.Fatalf("RequestProxy through SOCKS5 failed: %q, %v", proxyInvoice, err)
+		}
+	}
+	conns := socks.Conns()
+	if len(conns) != 2 {
+		t.Fatalf("Expected a connection per request, got %+v", conns)
+	}
+	for _, c := range conns {
+		if c.Target != onion {
+			t.Errorf("Expected the proxy to resolve %s, got %s", onion, c.Target)
+		}
+	}
+	if conns[0].Username == "" || conns[0].Username == conns[1].Username {
+		t.Errorf("Expected distinct isolation credentials per request, got %q and %q", conns[0].Username, conns[1].Username)
+	}
+
+	// Fixed credentials
+	x = newTestClient(relay.URL).WithSOCKS5(SOCKSProxy{Address: socks.Addr().String(), Username: "alice", Password: "secret"})
+	if _, err := x.RequestProxy("test-invoice", 1500); err != nil {
+		t.Fatalf("RequestProxy through SOCKS5 failed: %v", err)
+	}
+	if conns := socks.Conns(); conns[len(conns)-1].Username != "alice" {
+		t.Errorf("Expected username alice, got %+v", conns[len(conns)-1])
+	}
+}
+
+func TestRequestProxyOnionWithoutProxy(t *testing.T) {
+	x := newTestClient("http://lnproxyexample7.onion")
+	if _, err := x.RequestProxy("test-invoice", 1500); !errors.Is(err, OnionRequiresProxy) {
+		t.Errorf("Expected OnionRequiresProxy, got %v", err)
+	}
+	x.Client.Transport = http.DefaultTransport
+	if _, err := x.RequestProxy("test-invoice", 1500); !errors.Is(err, OnionRequiresProxy) {
+		t.Errorf("Expected OnionRequiresProxy with the default transport, got %v", err)
+	}
+
+	// Transports with a Proxy function that picks no SOCKS5 proxy
+	x.Client.Transport = http.DefaultTransport.(*http.Transport).Clone()
+	if _, err := x.RequestProxy("test-invoice", 1500); !errors.Is(err, OnionRequiresProxy) {
+		t.Errorf("Expected OnionRequiresProxy with a cloned default transport, got %v", err)
+	}
+	httpProxy, _ := url.Parse("http://127.0.0.1:3128")
+	x.Client.Transport = &http.Transport{Proxy: http.ProxyURL(httpProxy)}
+	if _, err := x.RequestProxy("test-invoice", 1500); !errors.Is(err, OnionRequiresProxy) {
+		t.Errorf("Expected OnionRequiresProxy with an HTTP proxy, got %v", err)
+	}
+
+	// A hand-built SOCKS5 transport is trusted
+	socksProxy, _ := url.Parse("socks5h://127.0.0.1:1")
+	x.Client.Transport = &http.Transport{Proxy: http.ProxyURL(socksProxy)}
+	if err := x.checkTransport(); err != nil {
+		t.Errorf("Expected a SOCKS5 transport to be accepted, got %v", err)
+	}
+}
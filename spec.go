@@ -0,0 +1,251 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	SpecUnavailable    = errors.New("relay spec unavailable")
+	OutsideRelayLimits = errors.New("request outside the relay's advertised limits")
+)
+
+// RequestStyle selects how LNProxy sends requests to its relay
+type RequestStyle int
+
+const (
+	// StyleJSON posts a JSON body to the relay URL.
+	StyleJSON RequestStyle = iota
+	// StylePath is the legacy GET {URL}/{invoice}?routing_msat= request.
+	StylePath
+	// StyleAuto picks the style from the relay's spec document and checks
+	// the request against the limits it advertises. Relays without a spec
+	// document get StyleJSON.
+	StyleAuto
+)
+
+func (s RequestStyle) String() string {
+	switch s {
+	case StyleJSON:
+		return "json"
+	case StylePath:
+		return "path"
+	case StyleAuto:
+		return "auto"
+	default:
+		return fmt.Sprintf("RequestStyle(%d)", int(s))
+	}
+}
+
+// DefaultSpecTTL is how long a relay's spec document is cached when the
+// client's SpecTTL is zero
+const DefaultSpecTTL = time.Hour
+
+// SpecFailureTTL is how long a failure to fetch a relay's spec document is
+// remembered, or SpecTTL if that is shorter
+const SpecFailureTTL = time.Minute
+
+// RelaySpec is the discovery document a relay serves at GET /spec. Zero
+// limits are not enforced.
+type RelaySpec struct {
+	Version string `json:"version,omitempty"`
+	// RequestStyles lists the request styles the relay accepts, "json"
+	// and "path", in order of preference.
+	RequestStyles  []string `json:"request_styles,omitempty"`
+	BaseFeeMsat    uint64   `json:"base_fee_msat,omitempty"`
+	FeePpm         uint64   `json:"fee_ppm,omitempty"`
+	MinAmountMsat  uint64   `json:"min_amount_msat,omitempty"`
+	MaxAmountMsat  uint64   `json:"max_amount_msat,omitempty"`
+	MinRoutingMsat uint64   `json:"min_routing_msat,omitempty"`
+	MaxRoutingMsat uint64   `json:"max_routing_msat,omitempty"`
+	// RelayChosenBudget, Description and DescriptionHash report whether the
+	// relay accepts requests without routing_msat, and with a description or
+	// description_hash override.
+	RelayChosenBudget bool `json:"relay_chosen_budget,omitempty"`
+	Description       bool `json:"description,omitempty"`
+	DescriptionHash   bool `json:"description_hash,omitempty"`
+}
+
+// Style returns the first request style of the spec the client supports.
+// A spec listing none is taken to accept StyleJSON.
+func (s *RelaySpec) Style() RequestStyle {
+	for _, style := range s.RequestStyles {
+		switch strings.ToLower(style) {
+		case "json":
+			return StyleJSON
+		case "path":
+			return StylePath
+		}
+	}
+	return StyleJSON
+}
+
+// Check reports whether the relay would accept an invoice of amountMsat with
+// a routing budget of routingMsat. A zero amount or budget is not checked.
+func (s *RelaySpec) Check(amountMsat, routingMsat uint64) error {
+	switch {
+	case amountMsat > 0 && amountMsat < s.MinAmountMsat:
+		return fmt.Errorf("%w: %w: %d msat below minimum of %d msat", OutsideRelayLimits, AmountTooSmall, amountMsat, s.MinAmountMsat)
+	case amountMsat > 0 && s.MaxAmountMsat > 0 && amountMsat > s.MaxAmountMsat:
+		return fmt.Errorf("%w: %d msat above maximum of %d msat", OutsideRelayLimits, amountMsat, s.MaxAmountMsat)
+	case routingMsat > 0 && routingMsat < s.MinRoutingMsat:
+		return fmt.Errorf("%w: routing budget of %d msat below minimum of %d msat", OutsideRelayLimits, routingMsat, s.MinRoutingMsat)
+	case routingMsat > 0 && s.MaxRoutingMsat > 0 && routingMsat > s.MaxRoutingMsat:
+		return fmt.Errorf("%w: routing budget of %d msat above maximum of %d msat", OutsideRelayLimits, routingMsat, s.MaxRoutingMsat)
+	}
+	return nil
+}
+
+// specCache holds the outcome of the last spec fetch from a relay
+type specCache struct {
+	mu      sync.Mutex
+	spec    *RelaySpec
+	err     error
+	fetched time.Time
+	// pending is closed when the fetch in flight completes
+	pending chan struct{}
+}
+
+// SpecURL returns the URL of the relay's spec document, /spec below the
+// relay URL. Relay URLs such as https://lnproxy.org/spec name the wrap
+// endpoint, so their spec document is looked for at /spec/spec.
+func (x *LNProxy) SpecURL() string {
+	return x.URL.JoinPath("spec").String()
+}
+
+// Spec returns the relay's spec document, fetching it when the cached copy
+// is older than the client's SpecTTL. A relay that serves no spec document
+// yields SpecUnavailable, which is remembered for SpecFailureTTL. Concurrent
+// callers share a single fetch.
+func (x *LNProxy) Spec(ctx context.Context) (*RelaySpec, error) {
+	if x.spec == nil {
+		return x.fetchSpec(ctx)
+	}
+	ttl := x.SpecTTL
+	if ttl == 0 {
+		ttl = DefaultSpecTTL
+	}
+	failureTTL := SpecFailureTTL
+	if ttl < failureTTL {
+		failureTTL = ttl
+	}
+
+	c := x.spec
+	for {
+		c.mu.Lock()
+		age := time.Since(c.fetched)
+		switch {
+		case c.spec != nil && age < ttl:
+			c.mu.Unlock()
+			return c.spec, nil
+		case c.err != nil && age < failureTTL:
+			c.mu.Unlock()
+			return nil, c.err
+		case c.pending != nil:
+			pending := c.pending
+			c.mu.Unlock()
+			select {
+			case <-pending:
+				continue
+			case <-ctx.Done():
+				return nil, x.timeoutError(ctx, ctx.Err())
+			}
+		}
+		done := make(chan struct{})
+		c.pending = done
+		c.mu.Unlock()
+
+		spec, err := x.fetchSpec(ctx)
+
+		c.mu.Lock()
+		c.pending = nil
+		// A fetch the caller gave up on says nothing about the relay
+		if err == nil || ctx.Err() == nil {
+			c.spec, c.err, c.fetched = spec, err, time.Now()
+		}
+		c.mu.Unlock()
+		close(done)
+		return spec, err
+	}
+}
+
+// fetchSpec downloads the relay's spec document
+func (x *LNProxy) fetchSpec(ctx context.Context) (*RelaySpec, error) {
+	if err := x.checkTransport(); err != nil {
+		return nil, err
+	}
+	parent := ctx
+	if x.RequestTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, x.RequestTimeout)
+		defer cancel()
+	}
+
+	x.logger.Debug("Fetching relay spec from %s", x.SpecURL())
+	req, err := http.NewRequestWithContext(ctx, "GET", x.SpecURL(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := x.Client.Do(req)
+	if err != nil {
+		x.logger.Warn("Cannot fetch relay spec: %v", err)
+		return nil, x.timeoutError(parent, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		x.logger.Warn("Relay spec request returned status %d", resp.StatusCode)
+		return nil, fmt.Errorf("%w: HTTP %d", SpecUnavailable, resp.StatusCode)
+	}
+	var spec RelaySpec
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxResponseBytes)).Decode(&spec); err != nil {
+		x.logger.Warn("Malformed relay spec: %v", err)
+		return nil, fmt.Errorf("%w: %w", SpecUnavailable, err)
+	}
+	x.logger.Info("Relay spec: styles %v, amount %d-%d msat, routing %d-%d msat",
+		spec.RequestStyles, spec.MinAmountMsat, spec.MaxAmountMsat, spec.MinRoutingMsat, spec.MaxRoutingMsat)
+	return &spec, nil
+}
+
+// autoStyle picks the request style from the relay's spec and checks the
+// request against the limits and options it advertises. original is nil
+// when the invoice cannot be decoded, and relayChosen is set when the
+// request leaves routing_msat out for the relay to choose.
+func (x *LNProxy) autoStyle(ctx context.Context, original *Invoice, routing_msat uint64, relayChosen bool, opts RequestOptions) (RequestStyle, error) {
+	spec, err := x.Spec(ctx)
+	if err != nil {
+		if ctx.Err() != nil {
+			return 0, err
+		}
+		x.logger.Warn("No usable spec from %s, sending JSON requests: %v", x.URL.String(), err)
+		return StyleJSON, nil
+	}
+
+	var amountMsat uint64
+	if original != nil {
+		amountMsat = original.AmountMsat
+	}
+	err = spec.Check(amountMsat, routing_msat)
+	switch {
+	case err != nil:
+	case routing_msat == 0 && relayChosen && !spec.RelayChosenBudget:
+		err = fmt.Errorf("%w: relay-chosen routing budget not supported", OutsideRelayLimits)
+	case opts.DescriptionHash != nil && !spec.DescriptionHash:
+		err = fmt.Errorf("%w: %w", OutsideRelayLimits, DescriptionHashUnsupported)
+	case opts.Description != "" && !spec.Description:
+		err = fmt.Errorf("%w: description override not supported", OutsideRelayLimits)
+	}
+	if err != nil {
+		x.logger.Error("Relay %s would reject the request: %v", x.URL.String(), err)
+		return 0, err
+	}
+	return spec.Style(), nil
+}
@@ -0,0 +1,269 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRequestProxyPathStyle(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" {
+			t.Errorf("Expected GET request, got %s", r.Method)
+		}
+		if r.URL.Path == "/api/invalid-invoice" {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("invoice expired\n"))
+			return
+		}
+		if r.URL.Path != "/api/test-invoice" || r.URL.Query().Get("routing_msat") != "1500" {
+			t.Errorf("Unexpected request %s", r.URL)
+		}
+		w.Write([]byte("proxy-test-invoice\n"))
+	}))
+	defer server.Close()
+
+	x := newTestClient(server.URL + "/api/")
+	x.Style = StylePath
+	if proxyInvoice, err := x.RequestProxy("test-invoice", 1500); err != nil || proxyInvoice != "proxy-test-invoice" {
+		t.Errorf("Expected plain text proxy invoice, got %q, %v", proxyInvoice, err)
+	}
+	_, err := x.RequestProxy("invalid-invoice", 1500)
+	var relayErr *RelayError
+	if !errors.As(err, &relayErr) || relayErr.Reason != "invoice expired" || !errors.Is(err, InvoiceExpired) {
+		t.Errorf("Expected RelayError with the plain text reason, got %v", err)
+	}
+}
+
+func TestRequestProxyAutoStyle(t *testing.T) {
+	spec := RelaySpec{
+		Version:        "1",
+		RequestStyles:  []string{"path", "json"},
+		MinAmountMsat:  1000,
+		MaxRoutingMsat: 1_000_000,
+	}
+	specRequests, wrapRequests := 0, 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/spec" {
+			specRequests++
+			json.NewEncoder(w).Encode(spec)
+			return
+		}
+		wrapRequests++
+		if r.Method != "GET" || !strings.HasPrefix(r.URL.Path, "/lnbc") {
+			t.Errorf("Expected path-style request, got %s %s", r.Method, r.URL.Path)
+		}
+		w.Write([]byte("proxy-test-invoice"))
+	}))
+	defer server.Close()
+	invoice := mustEncode(t, testInvoice(), testKey)
+
+	x := newTestClient(server.URL)
+	x.Style = StyleAuto
+	for i := 0; i < 2; i++ {
+		if _, err := x.RequestProxy(invoice, 1500); err != nil {
+			t.Fatalf("RequestProxy failed: %v", err)
+		}
+	}
+	if specRequests != 1 || wrapRequests != 2 {
+		t.Errorf("Expected one cached spec request and 2 wrap requests, got %d and %d", specRequests, wrapRequests)
+	}
+
+	// Requests the relay advertises it would reject are not sent
+	if _, err := x.RequestProxy(invoice, 2_000_000); !errors.Is(err, OutsideRelayLimits) {
+		t.Errorf("Expected OutsideRelayLimits for the routing budget, got %v", err)
+	}
+	x = newTestClient(server.URL)
+	x.Style = StyleAuto
+	spec.MinAmountMsat = 300_000_000
+	if _, err := x.RequestProxy(invoice, 1500); !errors.Is(err, OutsideRelayLimits) || !errors.Is(err, AmountTooSmall) {
+		t.Errorf("Expected AmountTooSmall, got %v", err)
+	}
+	if wrapRequests != 2 {
+		t.Errorf("Expected no request beyond the relay's limits, got %d", wrapRequests)
+	}
+}
+
+func TestRequestProxyAutoStyleRelayChosenBudget(t *testing.T) {
+	var spec RelaySpec
+	var routing []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/spec" {
+			json.NewEncoder(w).Encode(spec)
+			return
+		}
+		var body map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&body)
+		routing = append(routing, fmt.Sprint(body["routing_msat"]))
+		json.NewEncoder(w).Encode(map[string]string{"proxy_invoice": "proxy-test-invoice"})
+	}))
+	defer server.Close()
+	invoice := mustEncode(t, testInvoice(), testKey)
+
+	newClient := func() *LNProxy {
+		x := newTestClient(server.URL)
+		x.Style = StyleAuto
+		x.RelayChosenBudget = true
+		x.MaxBudgetMsat = 10_000
+		return x
+	}
+	if _, err := newClient().RequestProxy(invoice, 0); !errors.Is(err, OutsideRelayLimits) {
+		t.Errorf("Expected OutsideRelayLimits without relay_chosen_budget in the spec, got %v", err)
+	}
+	if len(routing) != 0 {
+		t.Errorf("Expected no request to reach the relay, got %v", routing)
+	}
+
+	// An explicit budget does not depend on the relay choosing one
+	if _, err := newClient().RequestProxy(invoice, 1500); err != nil {
+		t.Errorf("RequestProxy with a budget failed: %v", err)
+	}
+	spec.RelayChosenBudget = true
+	if _, err := newClient().RequestProxy(invoice, 0); err != nil {
+		t.Errorf("RequestProxy with relay_chosen_budget in the spec failed: %v", err)
+	}
+	if len(routing) != 2 || routing[0] != "1500" || routing[1] != "<nil>" {
+		t.Errorf("Expected a budgeted and a relay-chosen request, got routing_msat %v", routing)
+	}
+}
+
+func TestRequestProxyAutoStyleWithoutSpec(t *testing.T) {
+	specRequests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			specRequests++
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"proxy_invoice": "proxy-test-invoice"})
+	}))
+	defer server.Close()
+
+	x := newTestClient(server.URL)
+	x.Style = StyleAuto
+	if _, err := x.Spec(context.Background()); !errors.Is(err, SpecUnavailable) {
+		t.Errorf("Expected SpecUnavailable, got %v", err)
+	}
+	for i := 0; i < 3; i++ {
+		if proxyInvoice, err := x.RequestProxy("test-invoice", 1500); err != nil || proxyInvoice != "proxy-test-invoice" {
+			t.Errorf("Expected JSON fallback, got %q, %v", proxyInvoice, err)
+		}
+	}
+	if specRequests != 1 {
+		t.Errorf("Expected the missing spec to be remembered, got %d spec requests", specRequests)
+	}
+
+	// The failure is forgotten after SpecFailureTTL, or SpecTTL if shorter
+	x.spec.fetched = x.spec.fetched.Add(-SpecFailureTTL)
+	x.Spec(context.Background())
+	x.SpecTTL = time.Nanosecond
+	x.Spec(context.Background())
+	if specRequests != 3 {
+		t.Errorf("Expected the spec to be fetched again, got %d spec requests", specRequests)
+	}
+}
+
+func TestSpecConcurrent(t *testing.T) {
+	var mu sync.Mutex
+	specRequests := 0
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		specRequests++
+		mu.Unlock()
+		<-release
+		json.NewEncoder(w).Encode(RelaySpec{Version: "1"})
+	}))
+	defer server.Close()
+	x := newTestClient(server.URL)
+
+	// A caller giving up does not wait for the slow fetch
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		x.Spec(context.Background())
+	}()
+	for {
+		mu.Lock()
+		started := specRequests == 1
+		mu.Unlock()
+		if started {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := x.Spec(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected the waiting caller to time out, got %v", err)
+	}
+
+	errs := make(chan error, 5)
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := x.Spec(context.Background())
+			errs <- err
+		}()
+	}
+	close(release)
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Errorf("Spec failed: %v", err)
+		}
+	}
+	if specRequests != 1 {
+		t.Errorf("Expected concurrent callers to share one fetch, got %d", specRequests)
+	}
+}
+
+func TestSpecURL(t *testing.T) {
+	for raw, want := range map[string]string{
+		"https://relay.example":           "https://relay.example/spec",
+		"https://relay.example/api/":      "https://relay.example/api/spec",
+		"https://relay.example/spec":      "https://relay.example/spec/spec",
+		"http://relayexample7.onion/spec": "http://relayexample7.onion/spec/spec",
+	} {
+		if got := newTestClient(raw).SpecURL(); got != want {
+			t.Errorf("SpecURL of %s = %s, expected %s", raw, got, want)
+		}
+	}
+}
+
+func TestRequestProxyAutoStyleSpecEndpoint(t *testing.T) {
+	// A relay whose wrap endpoint is POST /spec, as on lnproxy.org
+	var gets []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == "POST" && r.URL.Path == "/spec":
+			json.NewEncoder(w).Encode(map[string]string{"proxy_invoice": "proxy-test-invoice"})
+		case r.Method == "GET":
+			gets = append(gets, r.URL.Path)
+			w.WriteHeader(http.StatusNotFound)
+		default:
+			t.Errorf("Unexpected request %s %s", r.Method, r.URL.Path)
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	}))
+	defer server.Close()
+
+	x := newTestClient(server.URL + "/spec")
+	x.Style = StyleAuto
+	if proxyInvoice, err := x.RequestProxy("test-invoice", 1500); err != nil || proxyInvoice != "proxy-test-invoice" {
+		t.Errorf("Expected JSON request to the wrap endpoint, got %q, %v", proxyInvoice, err)
+	}
+	if len(gets) != 1 || gets[0] != "/spec/spec" {
+		t.Errorf("Expected discovery below the wrap endpoint, got GET %v", gets)
+	}
+}
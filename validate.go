@@ -0,0 +1,359 @@
+package client
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// CheckStatus is the outcome of a single validation check.
+type CheckStatus int
+
+const (
+	CheckPass CheckStatus = iota
+	CheckFail
+	CheckSkip
+)
+
+// String returns the string representation of the CheckStatus
+func (s CheckStatus) String() string {
+	switch s {
+	case CheckPass:
+		return "PASS"
+	case CheckFail:
+		return "FAIL"
+	case CheckSkip:
+		return "SKIP"
+	default:
+		return "UNKNOWN"
+	}
+}
+
+// Names of the checks recorded in a ValidationReport, in the order they run.
+const (
+	CheckOriginal      = "original"
+	CheckProxy         = "proxy"
+	CheckNetwork       = "network"
+	CheckPaymentHash   = "payment_hash"
+	CheckDescription   = "description"
+	CheckAmount        = "amount"
+	CheckFeePolicy     = "fee_policy"
+	CheckPayee         = "payee"
+	CheckExpiry        = "expiry"
+	CheckCltv          = "cltv"
+	CheckFeatures      = "features"
+	CheckPaymentSecret = "payment_secret"
+)
+
+// CheckResult records the outcome of one validation check. Err holds the
+// sentinel error of a failed check and a short reason for a skipped one.
+type CheckResult struct {
+	Name     string
+	Status   CheckStatus
+	Expected string
+	Actual   string
+	Err      error
+}
+
+// ValidationReport holds the outcome of every check run against a proxy
+// invoice, together with both decoded invoices when they could be decoded.
+type ValidationReport struct {
+	Original *Invoice
+	Proxy    *Invoice
+	Checks   []CheckResult
+}
+
+// OK reports whether no check failed
+func (r *ValidationReport) OK() bool {
+	return r.FirstError() == nil
+}
+
+// FirstError returns the error of the first failed check, or nil
+func (r *ValidationReport) FirstError() error {
+	for _, c := range r.Checks {
+		if c.Status == CheckFail {
+			return c.Err
+		}
+	}
+	return nil
+}
+
+// Err returns the errors of all failed checks joined together, or nil. The
+// sentinel errors of the individual checks can be matched with errors.Is.
+func (r *ValidationReport) Err() error {
+	var errs []error
+	for _, c := range r.Checks {
+		if c.Status == CheckFail {
+			errs = append(errs, c.Err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Check returns the result of the named check
+func (r *ValidationReport) Check(name string) (CheckResult, bool) {
+	for _, c := range r.Checks {
+		if c.Name == name {
+			return c, true
+		}
+	}
+	return CheckResult{}, false
+}
+
+func (r *ValidationReport) pass(name, expected, actual string) {
+	r.Checks = append(r.Checks, CheckResult{Name: name, Status: CheckPass, Expected: expected, Actual: actual})
+}
+
+func (r *ValidationReport) fail(name, expected, actual string, err error) {
+	r.Checks = append(r.Checks, CheckResult{Name: name, Status: CheckFail, Expected: expected, Actual: actual, Err: err})
+}
+
+func (r *ValidationReport) skip(name, reason string) {
+	r.Checks = append(r.Checks, CheckResult{Name: name, Status: CheckSkip, Err: errors.New(reason)})
+}
+
+// record adds a pass or fail result depending on ok
+func (r *ValidationReport) record(ok bool, name, expected, actual string, err error) {
+	if ok {
+		r.pass(name, expected, actual)
+	} else {
+		r.fail(name, expected, actual, err)
+	}
+}
+
+// validationPolicy carries the parameters a proxy invoice is checked against.
+type validationPolicy struct {
+	routingMsat uint64
+	// feeLimit, when set, caps the fee implied by the proxy amount. With a
+	// zero routingMsat the expected budget is the limit itself, unless
+	// relayChosen is set.
+	feeLimit func(amountMsat uint64) uint64
+	// relayChosen accepts any budget up to feeLimit in place of an exact
+	// routingMsat.
+	relayChosen bool
+	// allowNetwork, when set, restricts the networks both invoices may use.
+	allowNetwork func(Network) bool
+	// options are those the proxy invoice was requested with.
+	options RequestOptions
+}
+
+// AuditProxyInvoice runs every validation check on a proxy invoice and
+// reports each outcome, rather than stopping at the first mismatch.
+func AuditProxyInvoice(invoice, proxy_invoice string, routing_msat uint64) *ValidationReport {
+	return AuditProxyInvoiceWithOptions(invoice, proxy_invoice, routing_msat, RequestOptions{})
+}
+
+// AuditProxyInvoiceWithOptions audits a proxy invoice requested with opts,
+// checking its description against the one opts asked for instead of the
+// original's.
+func AuditProxyInvoiceWithOptions(invoice, proxy_invoice string, routing_msat uint64, opts RequestOptions) *ValidationReport {
+	return audit(invoice, proxy_invoice, validationPolicy{routingMsat: routing_msat, options: opts})
+}
+
+// ValidateProxyInvoice checks that a proxy invoice pays the same hash with the
+// same description to a different node, for the original amount plus the
+// routing budget. It returns the error of the first failed check.
+func ValidateProxyInvoice(invoice, proxy_invoice string, routing_msat uint64) (bool, error) {
+	return ValidateProxyInvoiceWithOptions(invoice, proxy_invoice, routing_msat, RequestOptions{})
+}
+
+// ValidateProxyInvoiceWithOptions validates a proxy invoice requested with
+// opts, see AuditProxyInvoiceWithOptions
+func ValidateProxyInvoiceWithOptions(invoice, proxy_invoice string, routing_msat uint64, opts RequestOptions) (bool, error) {
+	err := AuditProxyInvoiceWithOptions(invoice, proxy_invoice, routing_msat, opts).FirstError()
+	return err == nil, err
+}
+
+// AuditProxyInvoice runs every validation check like the package-level
+// AuditProxyInvoice, and additionally requires both invoices to be for a
+// network the client accepts and the relay fee to respect the client's fee
+// policy. A routing_msat of zero expects the budget given by the policy, or
+// any budget up to BudgetCeiling when RelayChosenBudget is set; without a
+// ceiling the fee policy check fails with UnboundedRelayBudget.
+func (x *LNProxy) AuditProxyInvoice(invoice, proxy_invoice string, routing_msat uint64) *ValidationReport {
+	return x.AuditProxyInvoiceWithOptions(invoice, proxy_invoice, routing_msat, RequestOptions{})
+}
+
+// AuditProxyInvoiceWithOptions audits a proxy invoice requested with opts
+// like AuditProxyInvoice, checking its description against the one opts
+// asked for instead of the original's.
+func (x *LNProxy) AuditProxyInvoiceWithOptions(invoice, proxy_invoice string, routing_msat uint64, opts RequestOptions) *ValidationReport {
+	policy := validationPolicy{
+		routingMsat:  routing_msat,
+		allowNetwork: x.allowsNetwork,
+		options:      opts,
+	}
+	switch {
+	case routing_msat == 0 && x.RelayChosenBudget:
+		policy.relayChosen = true
+		if _, limited := x.BudgetCeiling(0); limited {
+			policy.feeLimit = func(amountMsat uint64) uint64 {
+				ceiling, _ := x.BudgetCeiling(amountMsat)
+				return ceiling
+			}
+		}
+	case x.hasFeePolicy():
+		policy.feeLimit = x.RoutingBudget
+	}
+	return audit(invoice, proxy_invoice, policy)
+}
+
+// ValidateProxyInvoice validates a proxy invoice like the package-level
+// ValidateProxyInvoice, applying the client's network and fee policy as
+// AuditProxyInvoice does.
+func (x *LNProxy) ValidateProxyInvoice(invoice, proxy_invoice string, routing_msat uint64) (bool, error) {
+	return x.ValidateProxyInvoiceWithOptions(invoice, proxy_invoice, routing_msat, RequestOptions{})
+}
+
+// ValidateProxyInvoiceWithOptions validates a proxy invoice requested with
+// opts, see AuditProxyInvoiceWithOptions
+func (x *LNProxy) ValidateProxyInvoiceWithOptions(invoice, proxy_invoice string, routing_msat uint64, opts RequestOptions) (bool, error) {
+	err := x.AuditProxyInvoiceWithOptions(invoice, proxy_invoice, routing_msat, opts).FirstError()
+	return err == nil, err
+}
+
+func audit(invoice, proxy_invoice string, policy validationPolicy) *ValidationReport {
+	logger := DefaultLogger().WithComponent("Validator")
+	logger.Debug("Validating proxy invoice against original invoice")
+
+	report := &ValidationReport{}
+	original, err := DecodeInvoice([]byte(invoice))
+	if err != nil {
+		logger.Error("Failed to parse original invoice: %v", err)
+		report.fail(CheckOriginal, "valid invoice", err.Error(), fmt.Errorf("invalid original invoice: %w", err))
+	} else {
+		report.Original = original
+		report.pass(CheckOriginal, "valid invoice", "valid invoice")
+	}
+	proxy, err := DecodeInvoice([]byte(proxy_invoice))
+	if err != nil {
+		logger.Error("Failed to parse proxy invoice: %v", err)
+		report.fail(CheckProxy, "valid invoice", err.Error(), fmt.Errorf("%w: %w", InvalidProxyInvoice, err))
+	} else {
+		report.Proxy = proxy
+		report.pass(CheckProxy, "valid invoice", "valid invoice")
+	}
+
+	checks := []string{CheckNetwork, CheckPaymentHash, CheckDescription, CheckAmount, CheckFeePolicy, CheckPayee, CheckExpiry, CheckCltv, CheckFeatures, CheckPaymentSecret}
+	if original == nil || proxy == nil {
+		for _, name := range checks {
+			report.skip(name, "invoice could not be decoded")
+		}
+		return report
+	}
+
+	logger.Debug("Original amount: %d msat, Proxy amount: %d msat", original.AmountMsat, proxy.AmountMsat)
+
+	switch {
+	case policy.allowNetwork != nil && !policy.allowNetwork(original.Network):
+		report.fail(CheckNetwork, "allowed network", original.Network.String(), fmt.Errorf("%w: %s", NetworkNotAllowed, original.Network))
+	case policy.allowNetwork != nil && !policy.allowNetwork(proxy.Network):
+		report.fail(CheckNetwork, "allowed network", proxy.Network.String(), fmt.Errorf("%w: %w: %s", InvalidProxyInvoice, NetworkNotAllowed, proxy.Network))
+	default:
+		report.record(original.Network == proxy.Network, CheckNetwork,
+			original.Network.String(), proxy.Network.String(), NetworkMismatch)
+	}
+
+	report.record(bytes.Equal(original.PaymentHash, proxy.PaymentHash), CheckPaymentHash,
+		hex.EncodeToString(original.PaymentHash), hex.EncodeToString(proxy.PaymentHash), PaymentHashMismatch)
+
+	expected := policy.options.description(original)
+	report.record(expected.Description == proxy.Description && bytes.Equal(expected.DescriptionHash, proxy.DescriptionHash),
+		CheckDescription, describe(expected), describe(proxy), DescriptionMismatch)
+
+	switch {
+	case original.AmountMsat == 0:
+		// The proxy for an amountless invoice is amountless too, the relay
+		// taking its budget out of the payment
+		report.record(proxy.AmountMsat == 0, CheckAmount,
+			"no amount", fmt.Sprintf("%d msat", proxy.AmountMsat), ZeroAmountMismatch)
+	case policy.relayChosen:
+		report.record(proxy.AmountMsat >= original.AmountMsat, CheckAmount,
+			fmt.Sprintf(">= %d msat", original.AmountMsat), fmt.Sprintf("%d msat", proxy.AmountMsat), CustomRoutingBudgetMismatch)
+	default:
+		routingMsat := policy.routingMsat
+		if routingMsat == 0 && policy.feeLimit != nil {
+			routingMsat = policy.feeLimit(original.AmountMsat)
+		}
+		expectedMsat := original.AmountMsat + routingMsat
+		report.record(expectedMsat == proxy.AmountMsat, CheckAmount,
+			fmt.Sprintf("%d msat", expectedMsat), fmt.Sprintf("%d msat", proxy.AmountMsat), CustomRoutingBudgetMismatch)
+	}
+
+	switch {
+	case policy.relayChosen && policy.feeLimit == nil:
+		report.fail(CheckFeePolicy, "budget ceiling", "none", UnboundedRelayBudget)
+	case policy.feeLimit == nil:
+		report.skip(CheckFeePolicy, "no fee policy")
+	case original.AmountMsat == 0:
+		report.skip(CheckFeePolicy, "amountless invoice")
+	default:
+		var feeMsat uint64
+		if proxy.AmountMsat > original.AmountMsat {
+			feeMsat = proxy.AmountMsat - original.AmountMsat
+		}
+		limit := policy.feeLimit(original.AmountMsat)
+		report.record(feeMsat <= limit, CheckFeePolicy,
+			fmt.Sprintf("<= %d msat", limit), fmt.Sprintf("%d msat", feeMsat), FeePolicyExceeded)
+	}
+
+	report.record(!bytes.Equal(original.Payee, proxy.Payee), CheckPayee,
+		"not "+hex.EncodeToString(original.Payee), hex.EncodeToString(proxy.Payee), DestinationNotProxied)
+
+	originalExpiry := original.Timestamp.Add(original.Expiry)
+	proxyExpiry := proxy.Timestamp.Add(proxy.Expiry)
+	report.record(!proxyExpiry.After(originalExpiry), CheckExpiry,
+		"by "+originalExpiry.UTC().Format(time.RFC3339), proxyExpiry.UTC().Format(time.RFC3339), ExpiryExceedsOriginal)
+
+	report.record(proxy.MinFinalCLTVExpiry >= original.MinFinalCLTVExpiry, CheckCltv,
+		fmt.Sprintf(">= %d", original.MinFinalCLTVExpiry), strconv.FormatUint(proxy.MinFinalCLTVExpiry, 10), FinalCltvTooLow)
+
+	if unknown := unknownRequiredFeatures(original.Features, proxy.Features); len(unknown) > 0 {
+		report.fail(CheckFeatures, fmt.Sprintf("required bits within %v", original.Features),
+			fmt.Sprintf("%v", proxy.Features), fmt.Errorf("%w: %v", FeaturesMismatch, unknown))
+	} else {
+		report.pass(CheckFeatures, fmt.Sprintf("required bits within %v", original.Features), fmt.Sprintf("%v", proxy.Features))
+	}
+
+	switch {
+	case original.PaymentSecret == nil:
+		report.skip(CheckPaymentSecret, "original invoice has no payment secret")
+	default:
+		expected := "not " + hex.EncodeToString(original.PaymentSecret)
+		report.record(proxy.PaymentSecret != nil && !bytes.Equal(original.PaymentSecret, proxy.PaymentSecret),
+			CheckPaymentSecret, expected, hex.EncodeToString(proxy.PaymentSecret), InvalidPaymentSecret)
+	}
+
+	for _, c := range report.Checks {
+		if c.Status == CheckFail {
+			logger.Error("Check %s failed: expected %s, got %s", c.Name, c.Expected, c.Actual)
+		}
+	}
+	if report.OK() {
+		logger.Debug("Proxy invoice validation successful")
+	}
+	return report
+}
+
+// describe renders the description or description hash of an invoice.
+func describe(inv *Invoice) string {
+	if inv.DescriptionHash != nil {
+		return "hash " + hex.EncodeToString(inv.DescriptionHash)
+	}
+	return strconv.Quote(inv.Description)
+}
+
+// unknownRequiredFeatures returns the required (even) feature bits of proxy
+// for which original sets neither the required nor the optional bit.
+func unknownRequiredFeatures(original, proxy FeatureVector) []int {
+	var unknown []int
+	for _, bit := range proxy {
+		if bit%2 == 0 && !original.IsSet(bit) && !original.IsSet(bit+1) {
+			unknown = append(unknown, bit)
+		}
+	}
+	return unknown
+}
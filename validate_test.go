@@ -0,0 +1,199 @@
+package client
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/decred/dcrd/dcrec/secp256k1/v4"
+)
+
+func TestValidateProxyInvoice(t *testing.T) {
+	original := mustEncode(t, testInvoice(), testKey)
+
+	tests := []struct {
+		name   string
+		key    *secp256k1.PrivateKey
+		modify func(*Invoice)
+		want   error
+	}{
+		{
+			name:   "valid",
+			key:    relayKey,
+			modify: func(*Invoice) {},
+		},
+		{
+			name:   "payment hash",
+			key:    relayKey,
+			modify: func(p *Invoice) { p.PaymentHash = bytes.Repeat([]byte{0x01}, 32) },
+			want:   PaymentHashMismatch,
+		},
+		{
+			name:   "description",
+			key:    relayKey,
+			modify: func(p *Invoice) { p.Description = "2 cups coffee" },
+			want:   DescriptionMismatch,
+		},
+		{
+			name:   "description hash",
+			key:    relayKey,
+			modify: func(p *Invoice) { p.DescriptionHash = bytes.Repeat([]byte{0x03}, 32) },
+			want:   DescriptionMismatch,
+		},
+		{
+			name:   "routing budget",
+			key:    relayKey,
+			modify: func(p *Invoice) { p.AmountMsat += 1 },
+			want:   CustomRoutingBudgetMismatch,
+		},
+		{
+			name:   "same payee",
+			key:    testKey,
+			modify: func(*Invoice) {},
+			want:   DestinationNotProxied,
+		},
+		{
+			name:   "network",
+			key:    relayKey,
+			modify: func(p *Invoice) { p.Network = Testnet },
+			want:   NetworkMismatch,
+		},
+		{
+			name:   "expiry",
+			key:    relayKey,
+			modify: func(p *Invoice) { p.Expiry += time.Second },
+			want:   ExpiryExceedsOriginal,
+		},
+		{
+			name:   "cltv",
+			key:    relayKey,
+			modify: func(p *Invoice) { p.MinFinalCLTVExpiry = 9 },
+			want:   FinalCltvTooLow,
+		},
+		{
+			name:   "features",
+			key:    relayKey,
+			modify: func(p *Invoice) { p.Features = FeatureVector{8, 14, 48} },
+			want:   FeaturesMismatch,
+		},
+		{
+			name:   "reused payment secret",
+			key:    relayKey,
+			modify: func(p *Invoice) { p.PaymentSecret = testInvoice().PaymentSecret },
+			want:   InvalidPaymentSecret,
+		},
+		{
+			name:   "missing payment secret",
+			key:    relayKey,
+			modify: func(p *Invoice) { p.PaymentSecret = nil },
+			want:   InvalidPaymentSecret,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			proxy := testProxyInvoice(testInvoice(), 100_000)
+			tt.modify(proxy)
+			ok, err := ValidateProxyInvoice(original, mustEncode(t, proxy, tt.key), 100_000)
+			if tt.want == nil {
+				if !ok || err != nil {
+					t.Fatalf("Expected valid proxy invoice, got %v", err)
+				}
+				return
+			}
+			if ok || !errors.Is(err, tt.want) {
+				t.Fatalf("Expected %v, got %v", tt.want, err)
+			}
+		})
+	}
+
+	// A proxy invoice claiming the original payee in its n field but signed
+	// by someone else must not pass as the original.
+	forged := rawInvoice(relayKey, "lnbc2501u", 1496314700,
+		taggedField('p', testInvoice().PaymentHash),
+		taggedField('d', []byte("1 cup coffee")),
+		taggedField('n', testKey.PubKey().SerializeCompressed()),
+	)
+	if ok, err := ValidateProxyInvoice(original, forged, 100_000); ok || !errors.Is(err, ErrInvalidSignature) || !errors.Is(err, InvalidProxyInvoice) {
+		t.Fatalf("Expected forged signature to be rejected, got %v", err)
+	}
+}
+
+func TestValidateProxyInvoiceOverflowingExpiry(t *testing.T) {
+	original := mustEncode(t, testInvoice(), testKey)
+	proxy := func(expiry []byte) string {
+		return rawInvoice(relayKey, "lnbc2501u", 1496314700,
+			taggedField('p', testInvoice().PaymentHash),
+			taggedField('s', bytes.Repeat([]byte{0x22}, 32)),
+			taggedField('d', []byte("1 cup coffee")),
+			taggedWords('x', expiry),
+		)
+	}
+
+	if ok, err := ValidateProxyInvoice(original, proxy([]byte{0, 16}), 100_000); !ok {
+		t.Fatalf("Expected a 16s expiry to be valid, got %v", err)
+	}
+	// 2^35 seconds overflows time.Duration and would wrap around to an
+	// expiry before the original's.
+	ok, err := ValidateProxyInvoice(original, proxy([]byte{1, 0, 0, 0, 0, 0, 0, 0}), 100_000)
+	if ok || !errors.Is(err, ErrInvalidField) || !errors.Is(err, InvalidProxyInvoice) {
+		t.Fatalf("Expected overflowing expiry to be rejected, got %v", err)
+	}
+}
+
+func TestAuditProxyInvoice(t *testing.T) {
+	original := mustEncode(t, testInvoice(), testKey)
+	proxy := testProxyInvoice(testInvoice(), 100_000)
+	proxy.PaymentHash = bytes.Repeat([]byte{0x01}, 32)
+	proxy.AmountMsat += 5
+	proxy.MinFinalCLTVExpiry = 144
+
+	report := AuditProxyInvoice(original, mustEncode(t, proxy, relayKey), 100_000)
+	if report.OK() {
+		t.Fatal("Expected report to contain failures")
+	}
+	if !errors.Is(report.Err(), PaymentHashMismatch) || !errors.Is(report.Err(), CustomRoutingBudgetMismatch) {
+		t.Errorf("Expected both failures in report error, got %v", report.Err())
+	}
+	if !errors.Is(report.FirstError(), PaymentHashMismatch) {
+		t.Errorf("Expected first error to be PaymentHashMismatch, got %v", report.FirstError())
+	}
+
+	want := map[string]CheckStatus{
+		CheckOriginal:      CheckPass,
+		CheckProxy:         CheckPass,
+		CheckNetwork:       CheckPass,
+		CheckPaymentHash:   CheckFail,
+		CheckDescription:   CheckPass,
+		CheckAmount:        CheckFail,
+		CheckFeePolicy:     CheckSkip,
+		CheckPayee:         CheckPass,
+		CheckExpiry:        CheckPass,
+		CheckCltv:          CheckPass,
+		CheckFeatures:      CheckPass,
+		CheckPaymentSecret: CheckPass,
+	}
+	if len(report.Checks) != len(want) {
+		t.Errorf("Expected %d checks, got %d", len(want), len(report.Checks))
+	}
+	for name, status := range want {
+		c, ok := report.Check(name)
+		if !ok || c.Status != status {
+			t.Errorf("Check %s: expected %s, got %s", name, status, c.Status)
+		}
+	}
+	amount, _ := report.Check(CheckAmount)
+	if amount.Expected != "250100000 msat" || amount.Actual != "250100005 msat" {
+		t.Errorf("Unexpected amount check values: expected %q, actual %q", amount.Expected, amount.Actual)
+	}
+
+	// An undecodable proxy invoice skips every comparison
+	report = AuditProxyInvoice(original, "lnbc1garbage", 100_000)
+	if !errors.Is(report.Err(), InvalidProxyInvoice) {
+		t.Errorf("Expected InvalidProxyInvoice, got %v", report.Err())
+	}
+	if c, _ := report.Check(CheckPaymentHash); c.Status != CheckSkip {
+		t.Errorf("Expected payment hash check to be skipped, got %s", c.Status)
+	}
+}
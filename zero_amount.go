@@ -0,0 +1,66 @@
+package client
+
+import (
+	"errors"
+)
+
+var (
+	ZeroAmountNotAllowed = errors.New("amountless invoices are not allowed")
+	ZeroAmountMismatch   = errors.New("proxy invoice for an amountless invoice has an amount")
+)
+
+// ZeroAmountPolicy says what the client does with invoices that carry no
+// amount. A relay wraps such an invoice in an amountless proxy invoice and
+// keeps its routing budget out of whatever the payer sends.
+type ZeroAmountPolicy int
+
+const (
+	// RejectZeroAmount refuses amountless invoices without contacting the
+	// relay.
+	RejectZeroAmount ZeroAmountPolicy = iota
+	// AllowZeroAmount passes amountless invoices on to the relay, computing
+	// the routing budget from ZeroAmountMsat.
+	AllowZeroAmount
+)
+
+func (p ZeroAmountPolicy) String() string {
+	switch p {
+	case RejectZeroAmount:
+		return "reject"
+	case AllowZeroAmount:
+		return "allow"
+	default:
+		return "unknown"
+	}
+}
+
+// WithZeroAmount allows amountless invoices, expecting them to be paid
+// amountMsat. The amount only sets the routing budget requested under the
+// client's fee policy; the proxy invoice remains amountless.
+func (x *LNProxy) WithZeroAmount(amountMsat uint64) *LNProxy {
+	x.ZeroAmount = AllowZeroAmount
+	x.ZeroAmountMsat = amountMsat
+	return x
+}
+
+// checkAmount applies the client's ZeroAmount policy to the decoded
+// invoice, nil when it could not be decoded
+func (x *LNProxy) checkAmount(inv *Invoice) error {
+	if inv == nil || inv.AmountMsat != 0 {
+		return nil
+	}
+	if x.ZeroAmount != AllowZeroAmount {
+		x.logger.Error("Refusing amountless invoice under the %s policy", x.ZeroAmount)
+		return ZeroAmountNotAllowed
+	}
+	x.logger.Debug("Wrapping amountless invoice, expecting %d msat", x.ZeroAmountMsat)
+	return nil
+}
+
+// budgetAmount returns the amount the routing budget for inv is computed from
+func (x *LNProxy) budgetAmount(inv *Invoice) uint64 {
+	if inv.AmountMsat == 0 && x.ZeroAmount == AllowZeroAmount {
+		return x.ZeroAmountMsat
+	}
+	return inv.AmountMsat
+}
@@ -0,0 +1,89 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// newAmountlessRelay starts a relay wrapping amountless invoices the way the
+// protocol defines, recording the routing budgets it is asked for
+func newAmountlessRelay(t *testing.T) (*LNProxy, *[]uint64) {
+	t.Helper()
+	var budgets []uint64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Invoice     string `json:"invoice"`
+			RoutingMsat uint64 `json:"routing_msat,string"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		budgets = append(budgets, req.RoutingMsat)
+		original, err := DecodeInvoice([]byte(req.Invoice))
+		if err != nil {
+			t.Errorf("Relay cannot decode invoice: %v", err)
+			return
+		}
+		proxy := testProxyInvoice(original, 0)
+		proxy_invoice, _ := EncodeInvoice(proxy, relayKey)
+		json.NewEncoder(w).Encode(map[string]string{"proxy_invoice": proxy_invoice})
+	}))
+	t.Cleanup(server.Close)
+	return newTestClient(server.URL), &budgets
+}
+
+func TestRequestProxyZeroAmount(t *testing.T) {
+	amountless := testInvoice()
+	amountless.AmountMsat = 0
+	invoice := mustEncode(t, amountless, testKey)
+
+	// Refused by default without contacting the relay
+	relay, budgets := newAmountlessRelay(t)
+	if _, err := relay.RequestProxy(invoice, 0); !errors.Is(err, ZeroAmountNotAllowed) {
+		t.Errorf("Expected ZeroAmountNotAllowed, got %v", err)
+	}
+	if len(*budgets) != 0 {
+		t.Errorf("Expected no request to reach the relay, got %d", len(*budgets))
+	}
+
+	// Allowed with the amount the caller expects to be paid
+	relay.WithZeroAmount(100_000_000)
+	if fee, err := relay.PreviewFee(invoice); err != nil || fee != relay.RoutingBudget(100_000_000) {
+		t.Errorf("Expected a budget for 100000 sat, got %d, %v", fee, err)
+	}
+	proxyInvoice, err := relay.RequestProxy(invoice, 0)
+	if err != nil {
+		t.Fatalf("RequestProxy failed: %v", err)
+	}
+	if want := relay.RoutingBudget(100_000_000); len(*budgets) != 1 || (*budgets)[0] != want {
+		t.Errorf("Expected the relay to be asked for %d msat, got %v", want, *budgets)
+	}
+	report := relay.AuditProxyInvoice(invoice, proxyInvoice, 0)
+	if !report.OK() {
+		t.Fatalf("Expected amountless proxy invoice to validate, got %v", report.Err())
+	}
+	if check, _ := report.Check(CheckFeePolicy); check.Status != CheckSkip {
+		t.Errorf("Expected fee policy check to be skipped, got %+v", check)
+	}
+}
+
+func TestValidateProxyInvoiceZeroAmount(t *testing.T) {
+	amountless := testInvoice()
+	amountless.AmountMsat = 0
+	invoice := mustEncode(t, amountless, testKey)
+
+	// A proxy asking for just the routing budget is not how the protocol
+	// wraps amountless invoices
+	proxyInvoice := mustEncode(t, testProxyInvoice(amountless, 1500), relayKey)
+	for _, routingMsat := range []uint64{0, 1500} {
+		if _, err := ValidateProxyInvoice(invoice, proxyInvoice, routingMsat); !errors.Is(err, ZeroAmountMismatch) {
+			t.Errorf("Expected ZeroAmountMismatch with routing_msat %d, got %v", routingMsat, err)
+		}
+	}
+
+	proxyInvoice = mustEncode(t, testProxyInvoice(amountless, 0), relayKey)
+	if ok, err := ValidateProxyInvoice(invoice, proxyInvoice, 1500); !ok || err != nil {
+		t.Errorf("Expected amountless proxy invoice to validate, got %v", err)
+	}
+}